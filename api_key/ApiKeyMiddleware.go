@@ -0,0 +1,239 @@
+package api_key
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/linxlib/conv"
+	"github.com/linxlib/fw"
+	"github.com/linxlib/fw_middlewares/auth"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ fw.IMiddlewareCtl = (*ApiKeyMiddleware)(nil)
+
+const (
+	apiKeyAttr = "ApiKey"
+	apiKeyName = "ApiKey"
+
+	// ApiKeyKey is the context key of the authenticated *Key
+	ApiKeyKey = "api_key"
+
+	// only persist last used time when it is older than this, avoid writing the store on every request
+	lastUsedInterval = time.Minute
+)
+
+type ApiKeyOption struct {
+	Store    string `yaml:"store" default:"./data/api_keys.json"` // file store path
+	Prefix   string `yaml:"prefix" default:"fwk_"`                // prefix of generated keys
+	AdminKey string `yaml:"admin_key" default:""`                 // sent in the X-Admin-Key header, admin routes are disabled when empty
+	Path     string `yaml:"path" default:"/apiKeys"`              // base path of admin routes
+}
+
+// ApiKeyMiddleware authenticates machine to machine calls by api key.
+// can be used on Controller
+//
+//	// @ApiKey header=X-API-Key query=api_key cookie=api_key scope=orders:read
+type ApiKeyMiddleware struct {
+	*fw.MiddlewareCtl
+	Logger  *logrus.Logger `inject:""`
+	options *ApiKeyOption
+	store   KeyStore
+	routed  bool
+	mu      sync.Mutex
+}
+
+func (a *ApiKeyMiddleware) DoInitOnce() {
+	a.LoadConfig("apiKey", a.options)
+	if a.store == nil {
+		store, err := NewFileStore(a.options.Store)
+		if err != nil {
+			panic("api key store: " + err.Error())
+		}
+		a.store = store
+	}
+}
+
+// lookup reads the key from header, query and cookie in order
+func lookup(context *fw.Context, header, query, cookie string) string {
+	fctx := context.GetFastContext()
+	if header != "" {
+		if v := fctx.Request.Header.Peek(header); len(v) > 0 {
+			return strings.TrimSpace(conv.String(v))
+		}
+	}
+	if query != "" {
+		if v := fctx.QueryArgs().Peek(query); len(v) > 0 {
+			return conv.String(v)
+		}
+	}
+	if cookie != "" {
+		if v := fctx.Request.Header.Cookie(cookie); len(v) > 0 {
+			return conv.String(v)
+		}
+	}
+	return ""
+}
+
+func (a *ApiKeyMiddleware) Execute(ctx *fw.MiddlewareContext) fw.HandlerFunc {
+	header := "X-API-Key"
+	if v := ctx.GetParam("header"); v != "" {
+		header = v
+	}
+	query := ctx.GetParam("query")
+	cookie := ctx.GetParam("cookie")
+	var scopes []string
+	if v := ctx.GetParam("scope"); v != "" {
+		scopes = strings.Split(v, ",")
+	}
+	return func(context *fw.Context) {
+		plain := lookup(context, header, query, cookie)
+		if plain == "" {
+			context.JSON(http.StatusUnauthorized, fw.H{"error": "api key required"})
+			return
+		}
+		now := time.Now()
+		key, err := verify(a.store, a.options.Prefix, plain, now)
+		if err != nil {
+			if errors.Is(err, ErrKeyNotFound) {
+				err = ErrKeyInvalid
+			}
+			context.JSON(http.StatusUnauthorized, fw.H{"error": err.Error()})
+			return
+		}
+		for _, scope := range scopes {
+			if !key.HasScope(strings.TrimSpace(scope)) {
+				context.JSON(http.StatusForbidden, fw.H{"error": "api key missing scope " + scope})
+				return
+			}
+		}
+		if now.Sub(key.LastUsedAt) > lastUsedInterval {
+			key.LastUsedAt = now
+			if err = a.store.Touch(key.ID, now); err != nil && a.Logger != nil {
+				a.Logger.WithError(err).Warnf("[ApiKey] last used time of %s not saved", key.ID)
+			}
+		}
+		key.Hash = ""
+		context.Set(ApiKeyKey, key)
+		context.Map(key)
+		auth.SetPrincipal(context, &auth.Principal{
+			ID:     key.ID,
+			Name:   key.Name,
+			Roles:  key.Scopes,
+			Method: auth.MethodApiKey,
+		})
+		ctx.Next(context)
+	}
+}
+
+type createKeyRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn string   `json:"expires_in"` // e.g. 720h, empty means never
+}
+
+// Router registers hidden admin routes to create, list and revoke keys
+func (a *ApiKeyMiddleware) Router(ctx *fw.MiddlewareContext) []*fw.RouteItem {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// the middleware may be attached to many controllers, only register once
+	if a.routed {
+		return nil
+	}
+	a.routed = true
+	return auth.AdminRoutes(a.options.AdminKey,
+		&fw.RouteItem{
+			Method:     "POST",
+			Path:       a.options.Path,
+			H:          a.createKey,
+			Middleware: a,
+		},
+		&fw.RouteItem{
+			Method:     "GET",
+			Path:       a.options.Path,
+			H:          a.listKeys,
+			Middleware: a,
+		},
+		&fw.RouteItem{
+			Method:     "DELETE",
+			Path:       a.options.Path + "/{id}",
+			H:          a.revokeKey,
+			Middleware: a,
+		},
+	)
+}
+
+func (a *ApiKeyMiddleware) createKey(context *fw.Context) {
+	req := new(createKeyRequest)
+	if body := context.GetFastContext().PostBody(); len(body) > 0 {
+		if err := json.Unmarshal(body, req); err != nil {
+			context.JSON(http.StatusBadRequest, fw.H{"error": err.Error()})
+			return
+		}
+	}
+	plain, key, err := generateKey(a.options.Prefix)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, fw.H{"error": err.Error()})
+		return
+	}
+	key.Name = req.Name
+	key.Scopes = req.Scopes
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil {
+			context.JSON(http.StatusBadRequest, fw.H{"error": err.Error()})
+			return
+		}
+		key.ExpiresAt = key.CreatedAt.Add(d)
+	}
+	if err = a.store.Save(key); err != nil {
+		context.JSON(http.StatusInternalServerError, fw.H{"error": err.Error()})
+		return
+	}
+	key.Hash = ""
+	// the plain key is only returned here
+	context.JSON(http.StatusOK, fw.H{"key": plain, "info": key})
+}
+
+func (a *ApiKeyMiddleware) listKeys(context *fw.Context) {
+	keys, err := a.store.List()
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, fw.H{"error": err.Error()})
+		return
+	}
+	for _, key := range keys {
+		key.Hash = ""
+	}
+	context.JSON(http.StatusOK, keys)
+}
+
+func (a *ApiKeyMiddleware) revokeKey(context *fw.Context) {
+	id, _ := context.GetFastContext().UserValue("id").(string)
+	key, err := a.store.Get(id)
+	if err != nil {
+		context.JSON(http.StatusNotFound, fw.H{"error": err.Error()})
+		return
+	}
+	key.Revoked = true
+	if err = a.store.Save(key); err != nil {
+		context.JSON(http.StatusInternalServerError, fw.H{"error": err.Error()})
+		return
+	}
+	context.String(http.StatusOK, "ok")
+}
+
+// NewApiKeyMiddleware creates the middleware, keys are kept in a json file store
+// configured by apiKey.store unless a custom store is given.
+func NewApiKeyMiddleware(store ...KeyStore) fw.IMiddlewareCtl {
+	a := &ApiKeyMiddleware{
+		MiddlewareCtl: fw.NewMiddlewareCtl(apiKeyName, apiKeyAttr),
+		options:       new(ApiKeyOption),
+	}
+	if len(store) > 0 {
+		a.store = store[0]
+	}
+	return a
+}
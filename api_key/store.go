@@ -0,0 +1,202 @@
+package api_key
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/linxlib/fw_middlewares/auth"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrKeyNotFound = errors.New("api key not found")
+	ErrKeyInvalid  = errors.New("api key invalid")
+	ErrKeyExpired  = errors.New("api key expired")
+	ErrKeyRevoked  = errors.New("api key revoked")
+)
+
+// Key is a stored api key. The plain key is never persisted, only its sha256 hash.
+type Key struct {
+	ID         string    `json:"id"`
+	Prefix     string    `json:"prefix"`
+	Name       string    `json:"name"`
+	Hash       string    `json:"hash,omitempty"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at,omitzero"`
+	LastUsedAt time.Time `json:"last_used_at,omitzero"`
+	Revoked    bool      `json:"revoked"`
+}
+
+// HasScope reports whether the key is granted scope. "*" grants every scope.
+func (k *Key) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, "*") || slices.Contains(k.Scopes, scope)
+}
+
+func (k *Key) expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && now.After(k.ExpiresAt)
+}
+
+// KeyStore persists api keys
+type KeyStore interface {
+	Get(id string) (*Key, error)
+	List() ([]*Key, error)
+	Save(key *Key) error
+	// Touch only sets the last used time, so it never overwrites a concurrent revoke
+	Touch(id string, at time.Time) error
+}
+
+// generateKey creates a new key in the form <prefix><id>.<secret>
+// the returned string is the only time the plain key is available.
+func generateKey(prefix string) (string, *Key, error) {
+	idBytes := make([]byte, 6)
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", nil, err
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", nil, err
+	}
+	id := hex.EncodeToString(idBytes)
+	plain := prefix + id + "." + base64.RawURLEncoding.EncodeToString(secretBytes)
+	return plain, &Key{
+		ID:        id,
+		Prefix:    prefix + id,
+		Hash:      hashKey(plain),
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+func hashKey(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseKeyID extracts the key id from a plain key
+func parseKeyID(prefix string, plain string) (string, bool) {
+	if !strings.HasPrefix(plain, prefix) {
+		return "", false
+	}
+	id, _, found := strings.Cut(plain[len(prefix):], ".")
+	if !found || id == "" {
+		return "", false
+	}
+	return id, true
+}
+
+// verify looks up the key by its id and compares the hash in constant time
+func verify(store KeyStore, prefix string, plain string, now time.Time) (*Key, error) {
+	id, ok := parseKeyID(prefix, plain)
+	if !ok {
+		return nil, ErrKeyInvalid
+	}
+	key, err := store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashKey(plain))) != 1 {
+		return nil, ErrKeyInvalid
+	}
+	if key.Revoked {
+		return nil, ErrKeyRevoked
+	}
+	if key.expired(now) {
+		return nil, ErrKeyExpired
+	}
+	return key, nil
+}
+
+// FileStore keeps all keys in a single json file
+type FileStore struct {
+	path string
+	mu   sync.RWMutex
+	keys map[string]*Key
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	fs := &FileStore{
+		path: path,
+		keys: make(map[string]*Key),
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fs, nil
+		}
+		return nil, err
+	}
+	var keys []*Key
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &keys); err != nil {
+			return nil, err
+		}
+	}
+	for _, key := range keys {
+		fs.keys[key.ID] = key
+	}
+	return fs, nil
+}
+
+func (f *FileStore) Get(id string) (*Key, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	key, ok := f.keys[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	k := *key
+	return &k, nil
+}
+
+func (f *FileStore) List() ([]*Key, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	keys := make([]*Key, 0, len(f.keys))
+	for _, key := range f.keys {
+		k := *key
+		keys = append(keys, &k)
+	}
+	slices.SortFunc(keys, func(a, b *Key) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return keys, nil
+}
+
+func (f *FileStore) Save(key *Key) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	k := *key
+	f.keys[key.ID] = &k
+	return f.flush()
+}
+
+func (f *FileStore) Touch(id string, at time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key, ok := f.keys[id]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	key.LastUsedAt = at
+	return f.flush()
+}
+
+// flush stores the keys ordered by creation time, f.mu must be held
+func (f *FileStore) flush() error {
+	keys := make([]*Key, 0, len(f.keys))
+	for _, key := range f.keys {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b *Key) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return auth.WriteJSONFile(f.path, keys)
+}
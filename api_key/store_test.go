@@ -0,0 +1,53 @@
+package api_key
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStoreTouchKeepsRevoke(t *testing.T) {
+	store, err := NewFileStore(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, key, err := generateKey("fwk_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Save(key); err != nil {
+		t.Fatal(err)
+	}
+	// a request verified the key, then an admin revokes it before the last used time is saved
+	used, err := verify(store, "fwk_", plain, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, _ := store.Get(key.ID)
+	revoked.Revoked = true
+	if err = store.Save(revoked); err != nil {
+		t.Fatal(err)
+	}
+	at := time.Now().Truncate(time.Second)
+	if err = store.Touch(used.ID, at); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewFileStore(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reopened.Get(key.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Revoked || !got.LastUsedAt.Equal(at) {
+		t.Fatalf("revoked = %v, last used = %s", got.Revoked, got.LastUsedAt)
+	}
+	if _, err = verify(reopened, "fwk_", plain, time.Now()); !errors.Is(err, ErrKeyRevoked) {
+		t.Fatalf("verify = %v, want ErrKeyRevoked", err)
+	}
+	if err = store.Touch("missing", at); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("touch missing = %v", err)
+	}
+}
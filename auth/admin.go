@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"github.com/linxlib/conv"
+	"github.com/linxlib/fw"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"strings"
+)
+
+// AdminHeader carries the key of the hidden admin routes of the middlewares
+const AdminHeader = "X-Admin-Key"
+
+// CheckAdmin reports whether the request carries key in the X-Admin-Key header. a {key} path segment,
+// which ends up in access logs, is still accepted for paths configured with one. an empty key never matches.
+func CheckAdmin(fctx *fasthttp.RequestCtx, key string) bool {
+	if key == "" {
+		return false
+	}
+	str := conv.String(fctx.Request.Header.Peek(AdminHeader))
+	if str == "" {
+		str, _ = fctx.UserValue("key").(string)
+	}
+	str = strings.TrimSpace(str)
+	return subtle.ConstantTimeCompare(conv.Bytes(str), conv.Bytes(key)) == 1
+}
+
+// AdminRoutes hides the routes and guards their handlers with CheckAdmin,
+// the routes are disabled when key is empty
+//
+//	return auth.AdminRoutes(m.options.AdminKey, &fw.RouteItem{Method: "GET", Path: m.options.Path, H: m.list, Middleware: m})
+func AdminRoutes(key string, routes ...*fw.RouteItem) []*fw.RouteItem {
+	if key == "" {
+		return nil
+	}
+	for _, route := range routes {
+		h := route.H
+		route.IsHide = true
+		route.H = func(context *fw.Context) {
+			if !CheckAdmin(context.GetFastContext(), key) {
+				context.JSON(http.StatusForbidden, fw.H{"error": "forbidden"})
+				return
+			}
+			h(context)
+		}
+	}
+	return routes
+}
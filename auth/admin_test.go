@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"github.com/valyala/fasthttp"
+	"testing"
+)
+
+func TestCheckAdmin(t *testing.T) {
+	cases := []struct {
+		name   string
+		key    string
+		header string
+		path   string
+		ok     bool
+	}{
+		{name: "header", key: "secret", header: "secret", ok: true},
+		{name: "path", key: "secret", path: "secret", ok: true},
+		{name: "header wins", key: "secret", header: "wrong", path: "secret"},
+		{name: "wrong", key: "secret", header: "wrong"},
+		{name: "missing", key: "secret"},
+		{name: "disabled"},
+	}
+	for _, c := range cases {
+		fctx := new(fasthttp.RequestCtx)
+		if c.header != "" {
+			fctx.Request.Header.Set(AdminHeader, c.header)
+		}
+		if c.path != "" {
+			fctx.SetUserValue("key", c.path)
+		}
+		if ok := CheckAdmin(fctx, c.key); ok != c.ok {
+			t.Errorf("%s: CheckAdmin = %v, want %v", c.name, ok, c.ok)
+		}
+	}
+}
+
+func TestAdminRoutesDisabled(t *testing.T) {
+	if routes := AdminRoutes(""); routes != nil {
+		t.Fatalf("routes without key = %v, want nil", routes)
+	}
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxLockout caps the doubling lockout of a user who keeps failing
+const maxLockout = 24 * time.Hour
+
+type attemptState struct {
+	mu       sync.Mutex // held while an attempt of the user runs
+	refs     int        // attempts running or waiting, guarded by AttemptLimiter.mu
+	failures int        // consecutive failures since the last success
+	lockouts int        // lockouts since the last success, each one doubles the next
+	until    time.Time
+}
+
+// AttemptLimiter locks a user out after max consecutive failed attempts, e.g. wrong passwords or codes,
+// the lockout doubles every time it is reached again until an attempt succeeds
+type AttemptLimiter struct {
+	max     int
+	lockout time.Duration
+	ignore  []error
+	users   map[string]*attemptState
+	mu      sync.Mutex
+}
+
+// NewAttemptLimiter creates the limiter, max 0 disables it. errors matching ignore are not counted as failures.
+func NewAttemptLimiter(max int, lockout time.Duration, ignore ...error) *AttemptLimiter {
+	return &AttemptLimiter{max: max, lockout: lockout, ignore: ignore, users: make(map[string]*attemptState)}
+}
+
+// Try runs verify unless the user is locked out and records its result. the check, the verification
+// and the accounting happen under a lock of the user, so parallel guesses can not get past max while
+// other users are not held up. it returns the lockout the user has to wait, the error of verify is only
+// returned when verify ran.
+func (l *AttemptLimiter) Try(user string, now time.Time, verify func() error) (time.Duration, error) {
+	if l.max <= 0 {
+		return 0, verify()
+	}
+	st := l.acquire(user)
+	st.mu.Lock()
+	wait, err := l.try(st, now, verify)
+	// a user without failures needs no state
+	clean := st.failures == 0 && st.lockouts == 0
+	st.mu.Unlock()
+	l.release(user, st, clean)
+	return wait, err
+}
+
+// try runs one attempt, st.mu must be held
+func (l *AttemptLimiter) try(st *attemptState, now time.Time, verify func() error) (time.Duration, error) {
+	if now.Before(st.until) {
+		return st.until.Sub(now), nil
+	}
+	err := verify()
+	switch {
+	case err == nil:
+		st.failures, st.lockouts, st.until = 0, 0, time.Time{}
+	case slices.ContainsFunc(l.ignore, func(target error) bool { return errors.Is(err, target) }):
+	default:
+		st.failures++
+		if st.failures < l.max {
+			return 0, err
+		}
+		d := l.lockout << st.lockouts
+		if d <= 0 || d > maxLockout {
+			d = maxLockout
+		}
+		st.failures = 0
+		st.lockouts++
+		st.until = now.Add(d)
+		return d, err
+	}
+	return 0, err
+}
+
+func (l *AttemptLimiter) acquire(user string) *attemptState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st, ok := l.users[user]
+	if !ok {
+		st = &attemptState{}
+		l.users[user] = st
+	}
+	st.refs++
+	return st
+}
+
+func (l *AttemptLimiter) release(user string, st *attemptState, clean bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st.refs--
+	if st.refs == 0 && clean {
+		delete(l.users, user)
+	}
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errWrong = errors.New("wrong")
+
+func fail() error {
+	return errWrong
+}
+
+func TestAttemptLimiterLocksOutAndBacksOff(t *testing.T) {
+	l := NewAttemptLimiter(3, time.Minute)
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if d, err := l.Try("alice", now, fail); d != 0 || err != errWrong {
+			t.Fatalf("failure %d = %s, %v", i+1, d, err)
+		}
+	}
+	if d, _ := l.Try("alice", now, fail); d != time.Minute {
+		t.Fatalf("third failure locked out for %s, want 1m", d)
+	}
+	ran := false
+	d, err := l.Try("alice", now.Add(30*time.Second), func() error {
+		ran = true
+		return nil
+	})
+	if d != 30*time.Second || err != nil || ran {
+		t.Fatalf("locked = %s, %v, verified %v", d, err, ran)
+	}
+	if d, err = l.Try("bob", now, fail); d != 0 || err != errWrong {
+		t.Fatalf("bob = %s, %v", d, err)
+	}
+	// the next lockout doubles
+	now = now.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		d, _ = l.Try("alice", now, fail)
+	}
+	if d != 2*time.Minute {
+		t.Fatalf("second lockout = %s, want 2m", d)
+	}
+	now = now.Add(2 * time.Minute)
+	if d, err = l.Try("alice", now, func() error { return nil }); d != 0 || err != nil {
+		t.Fatalf("success = %s, %v", d, err)
+	}
+	if d, _ = l.Try("alice", now, fail); d != 0 {
+		t.Fatalf("failures were kept after a success, locked for %s", d)
+	}
+}
+
+func TestAttemptLimiterDisabled(t *testing.T) {
+	l := NewAttemptLimiter(0, time.Minute)
+	for i := 0; i < 100; i++ {
+		if d, _ := l.Try("alice", time.Now(), fail); d != 0 {
+			t.Fatalf("locked out for %s with max 0", d)
+		}
+	}
+}
+
+func TestAttemptLimiterParallelGuesses(t *testing.T) {
+	l := NewAttemptLimiter(3, time.Minute)
+	var verified atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = l.Try("alice", time.Now(), func() error {
+				verified.Add(1)
+				// widen the window between the lockout check and the accounting
+				time.Sleep(time.Millisecond)
+				return errWrong
+			})
+		}()
+	}
+	wg.Wait()
+	if n := verified.Load(); n > 3 {
+		t.Fatalf("%d attempts were verified, want at most 3", n)
+	}
+}
+
+func TestAttemptLimiterOtherUsersNotBlocked(t *testing.T) {
+	l := NewAttemptLimiter(3, time.Minute)
+	slow := make(chan struct{})
+	go func() {
+		_, _ = l.Try("alice", time.Now(), func() error {
+			<-slow
+			return nil
+		})
+	}()
+	done := make(chan struct{})
+	go func() {
+		_, _ = l.Try("bob", time.Now(), fail)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("bob waited for the attempt of alice")
+	}
+	close(slow)
+}
+
+func TestAttemptLimiterIgnore(t *testing.T) {
+	errNotEnrolled := errors.New("not enrolled")
+	l := NewAttemptLimiter(1, time.Minute, errNotEnrolled)
+	for i := 0; i < 3; i++ {
+		if wait, err := l.Try("alice", time.Now(), func() error { return errNotEnrolled }); wait != 0 || !errors.Is(err, errNotEnrolled) {
+			t.Fatalf("Try = %s, %v", wait, err)
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.users) != 0 {
+		t.Fatalf("%d users kept without failures", len(l.users))
+	}
+}
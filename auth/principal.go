@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"github.com/linxlib/fw"
+	"slices"
+)
+
+// PrincipalKey is the context key of the authenticated *Principal
+const PrincipalKey = "principal"
+
+// auth methods set by the middlewares of this repo
+const (
+	MethodBasic      = "basic"
+	MethodProxyBasic = "proxy_basic"
+	MethodLDAP       = "ldap"
+	MethodForm       = "form"
+	MethodApiKey     = "api_key"
+	MethodJWT        = "jwt"
+	MethodOIDC       = "oidc"
+	MethodMTLS       = "mtls"
+	MethodSignature  = "signature"
+)
+
+// Principal is the authenticated caller, every auth middleware maps it into fw.Context
+// so controllers can take *auth.Principal as a parameter whatever the auth scheme is.
+type Principal struct {
+	// ID is the stable identifier: user name, key id, token subject, certificate CN...
+	ID string `json:"id"`
+	// Name is for display, falls back to ID
+	Name string `json:"name"`
+	// Roles are roles, groups or scopes granted to the principal
+	Roles []string `json:"roles,omitempty"`
+	// Method is the auth method which authenticated the principal, see Method* constants
+	Method string `json:"method"`
+	// Attributes holds scheme specific details, e.g. token claims or certificate SANs
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+func (p *Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+// DisplayName returns Name or ID when Name is empty
+func (p *Principal) DisplayName() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return p.ID
+}
+
+// SetAttribute sets a scheme specific detail, e.g. a step-up factor
+func (p *Principal) SetAttribute(key string, value any) {
+	if p.Attributes == nil {
+		p.Attributes = make(map[string]any)
+	}
+	p.Attributes[key] = value
+}
+
+// SetPrincipal stores p under PrincipalKey and maps it for injection
+func SetPrincipal(context *fw.Context, p *Principal) {
+	context.Set(PrincipalKey, p)
+	context.Map(p)
+}
+
+// FromContext returns the principal of the request, nil when not authenticated
+func FromContext(context *fw.Context) *Principal {
+	if v, ok := context.Get(PrincipalKey); ok {
+		if p, ok := v.(*Principal); ok {
+			return p
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"github.com/linxlib/conv"
+	"github.com/valyala/fasthttp"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// SafeRedirect only allows local paths, so a login can not be used as an open redirect
+func SafeRedirect(target string) string {
+	if target == "" || !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+	return target
+}
+
+func originAllowed(origin string, host string, scheme string, trusted []string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, host) && (scheme == "" || u.Scheme == scheme) {
+		return true
+	}
+	return slices.ContainsFunc(trusted, func(o string) bool {
+		return strings.EqualFold(strings.TrimSuffix(o, "/"), u.Scheme+"://"+u.Host)
+	})
+}
+
+// CheckOrigin verifies Origin, or Referer when Origin is missing, against the request host and the
+// trusted origins, e.g. https://admin.example.com. it returns why the request is rejected.
+func CheckOrigin(fctx *fasthttp.RequestCtx, trusted []string) (string, bool) {
+	host := conv.String(fctx.Host())
+	scheme := ""
+	if fctx.IsTLS() {
+		scheme = "https"
+	}
+	if origin := conv.String(fctx.Request.Header.Peek("Origin")); origin != "" {
+		if origin == "null" || !originAllowed(origin, host, scheme, trusted) {
+			return "origin not allowed", false
+		}
+		return "", true
+	}
+	if referer := conv.String(fctx.Request.Header.Referer()); referer != "" {
+		if !originAllowed(referer, host, scheme, trusted) {
+			return "referer not allowed", false
+		}
+		return "", true
+	}
+	if fctx.IsTLS() {
+		// browsers always send a referer for same origin https requests unless stripped by policy
+		return "referer missing", false
+	}
+	return "", true
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"github.com/valyala/fasthttp"
+	"testing"
+)
+
+func TestSafeRedirect(t *testing.T) {
+	cases := map[string]string{
+		"":                    "/",
+		"/":                   "/",
+		"/admin?tab=1":        "/admin?tab=1",
+		"//evil.com":          "/",
+		"/\\evil.com":         "/",
+		"https://evil.com":    "/",
+		"javascript:alert(1)": "/",
+	}
+	for target, want := range cases {
+		if got := SafeRedirect(target); got != want {
+			t.Errorf("SafeRedirect(%q) = %q, want %q", target, got, want)
+		}
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	trusted := []string{"https://admin.example.com/"}
+	cases := []struct {
+		name    string
+		origin  string
+		referer string
+		ok      bool
+	}{
+		{name: "no headers", ok: true},
+		{name: "same origin", origin: "http://app.example.com", ok: true},
+		{name: "trusted origin", origin: "https://admin.example.com", ok: true},
+		{name: "cross origin", origin: "https://evil.com"},
+		{name: "opaque origin", origin: "null"},
+		{name: "same referer", referer: "http://app.example.com/login", ok: true},
+		{name: "cross referer", referer: "https://evil.com/login"},
+		{name: "origin wins", origin: "https://evil.com", referer: "http://app.example.com/login"},
+	}
+	for _, c := range cases {
+		fctx := new(fasthttp.RequestCtx)
+		fctx.Request.Header.SetMethod("POST")
+		fctx.Request.Header.SetHost("app.example.com")
+		if c.origin != "" {
+			fctx.Request.Header.Set("Origin", c.origin)
+		}
+		if c.referer != "" {
+			fctx.Request.Header.SetReferer(c.referer)
+		}
+		if reason, ok := CheckOrigin(fctx, trusted); ok != c.ok {
+			t.Errorf("%s: ok = %v (%s), want %v", c.name, ok, reason, c.ok)
+		}
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"encoding/base64"
 	"github.com/linxlib/conv"
 	"github.com/linxlib/fw"
+	"github.com/linxlib/fw_middlewares/auth"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 var _ fw.IMiddlewareCtl = (*BasicAuthMiddleware)(nil)
@@ -57,11 +59,86 @@ func authorizationHeader(user, password string) string {
 	return "Basic " + base64.StdEncoding.EncodeToString(conv.Bytes(base))
 }
 
+// CredentialProvider verifies credentials which are not listed on the attribute, e.g. against LDAP
+type CredentialProvider interface {
+	Authenticate(user, password string) (*auth.Principal, error)
+}
+
+// parseAuthorization decodes a Basic authorization header
+func parseAuthorization(header string) (string, string, bool) {
+	encoded, ok := strings.CutPrefix(header, "Basic ")
+	if !ok {
+		return "", "", false
+	}
+	bs, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(conv.String(bs), ":")
+}
+
+// BasicAuthMiddleware checks accounts listed on the attribute, then the credential provider if any.
+// an LDAP provider is created when ldap.url is configured.
+// can be used on Controller
+//
+//	// @BasicAuth admin=secret
+//	// @BasicAuth proxy=true alice=secret
 type BasicAuthMiddleware struct {
 	*fw.MiddlewareCtl
-	realm string
-	proxy bool
-	pairs authPairs
+	realm    string
+	proxy    bool
+	ldap     *LdapOption
+	provider CredentialProvider
+}
+
+func (b *BasicAuthMiddleware) DoInitOnce() {
+	b.LoadConfig("ldap", b.ldap)
+	if b.provider == nil && b.ldap.URL != "" {
+		provider, err := NewLdapProvider(b.ldap)
+		if err != nil {
+			panic(err.Error())
+		}
+		b.provider = provider
+	}
+}
+
+// Checker verifies Basic credentials outside of BasicAuthMiddleware, e.g. in the forward proxy
+type Checker struct {
+	pairs    authPairs
+	provider CredentialProvider
+}
+
+// NewChecker checks accounts, then provider when it is not nil. it panics when both are empty.
+func NewChecker(accounts Accounts, provider CredentialProvider) *Checker {
+	c := &Checker{provider: provider}
+	if len(accounts) > 0 || provider == nil {
+		c.pairs = processAccounts(accounts)
+	}
+	return c
+}
+
+// Check returns the principal of the credentials in the header value, method is set on the principal
+// whether the account is listed or comes from the provider
+func (c *Checker) Check(header string, method string) (*auth.Principal, bool) {
+	if user, found := c.pairs.searchCredential(header); found {
+		return &auth.Principal{ID: user, Method: method}, true
+	}
+	if c.provider == nil {
+		return nil, false
+	}
+	user, password, ok := parseAuthorization(header)
+	if !ok {
+		return nil, false
+	}
+	principal, err := c.provider.Authenticate(user, password)
+	if err != nil {
+		return nil, false
+	}
+	// the provider tells who the user is, the header tells how the request authenticated.
+	// copied, providers may hand out shared principals
+	checked := *principal
+	checked.Method = method
+	return &checked, true
 }
 
 func (b *BasicAuthMiddleware) Execute(ctx *fw.MiddlewareContext) fw.HandlerFunc {
@@ -86,40 +163,50 @@ func (b *BasicAuthMiddleware) Execute(ctx *fw.MiddlewareContext) fw.HandlerFunc
 		accounts[key] = value[0]
 	})
 
-	b.pairs = processAccounts(accounts)
+	// accounts are optional when a provider verifies credentials
+	checker := NewChecker(accounts, b.provider)
 
 	if b.proxy {
 		return func(context *fw.Context) {
 			bs := context.GetFastContext().Request.Header.Peek("Proxy-Authorization")
-			proxyUser, found := b.pairs.searchCredential(conv.String(bs))
+			principal, found := checker.Check(conv.String(bs), auth.MethodProxyBasic)
 			if !found {
 				// Credentials doesn't match, we return 407 and abort handlers chain.
 				context.GetFastContext().Response.Header.Set("Proxy-Authenticate", b.realm)
 				context.GetFastContext().Response.SetStatusCode(http.StatusProxyAuthRequired)
 				return
 			}
-			context.Set(AuthProxyUserKey, proxyUser)
+			context.Set(AuthProxyUserKey, principal.ID)
+			auth.SetPrincipal(context, principal)
 			ctx.Next(context)
 		}
 	} else { //basic auth
 		return func(context *fw.Context) {
 			bs := context.GetFastContext().Request.Header.Peek("Authorization")
-			user, found := b.pairs.searchCredential(conv.String(bs))
+			principal, found := checker.Check(conv.String(bs), auth.MethodBasic)
 			if !found {
 				// Credentials doesn't match, we return 407 and abort handlers chain.
 				context.GetFastContext().Response.Header.Set("WWW-Authenticate", b.realm)
 				context.GetFastContext().Response.SetStatusCode(http.StatusUnauthorized)
 				return
 			}
-			context.Set(AuthUserKey, user)
+			context.Set(AuthUserKey, principal.ID)
+			auth.SetPrincipal(context, principal)
 			ctx.Next(context)
 		}
 	}
 
 }
 
-func NewBasicAuthMiddleware() fw.IMiddlewareCtl {
-	return &BasicAuthMiddleware{
+// NewBasicAuthMiddleware creates the middleware, pass a provider to verify credentials
+// not listed on the attribute, otherwise the LDAP provider is used when ldap.url is configured.
+func NewBasicAuthMiddleware(provider ...CredentialProvider) fw.IMiddlewareCtl {
+	b := &BasicAuthMiddleware{
 		MiddlewareCtl: fw.NewMiddlewareCtl("BasicAuth", "BasicAuth"),
+		ldap:          new(LdapOption),
+	}
+	if len(provider) > 0 {
+		b.provider = provider[0]
 	}
+	return b
 }
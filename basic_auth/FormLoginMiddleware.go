@@ -0,0 +1,432 @@
+package basic_auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/linxlib/conv"
+	"github.com/linxlib/fw"
+	"github.com/linxlib/fw_middlewares/auth"
+	"github.com/valyala/fasthttp"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ fw.IMiddlewareCtl = (*FormLoginMiddleware)(nil)
+
+const (
+	formLoginAttr = "FormLogin"
+	formLoginName = "FormLogin"
+)
+
+type FormLoginOption struct {
+	Secret     string `yaml:"secret" default:""` // signs the login cookie, at least 32 bytes
+	CookieName string `yaml:"cookie_name" default:"fw_login"`
+	Path       string `yaml:"path" default:"/"`
+	Domain     string `yaml:"domain" default:""`
+	Secure     bool   `yaml:"secure" default:"false"`
+	SameSite   string `yaml:"same_site" default:"lax"` // lax, strict or none
+	MaxAge     string `yaml:"max_age" default:"12h"`   // how long a login lasts
+	LoginPath  string `yaml:"login_path" default:"/login"`
+	LogoutPath string `yaml:"logout_path" default:"/logout"`
+	Title      string `yaml:"title" default:"Sign in"`
+	Template   string `yaml:"template" default:""` // html/template file of the login page, the built-in page when empty
+
+	MaxAttempts int    `yaml:"max_attempts" default:"5"` // consecutive failed logins before the user is locked out, 0 disables it
+	Lockout     string `yaml:"lockout" default:"5m"`     // doubled on every further lockout, up to 24h
+	// origins besides the host itself allowed to post the login and logout forms, e.g. https://admin.example.com
+	TrustedOrigins []string `yaml:"trusted_origins"`
+}
+
+// loginPage is the data of the login page template
+type loginPage struct {
+	Title    string
+	Action   string
+	Redirect string
+	User     string
+	Error    string
+}
+
+const defaultLoginTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body{font-family:-apple-system,BlinkMacSystemFont,"Segoe UI",Roboto,sans-serif;background:#f3f4f6;display:flex;align-items:center;justify-content:center;min-height:100vh;margin:0}
+form{background:#fff;padding:2rem;border-radius:8px;box-shadow:0 2px 12px rgba(0,0,0,.08);width:300px}
+h1{font-size:1.25rem;margin:0 0 1.5rem}
+label{display:block;font-size:.875rem;margin-bottom:.25rem}
+input{box-sizing:border-box;width:100%;padding:.5rem;margin-bottom:1rem;border:1px solid #d1d5db;border-radius:4px}
+button{width:100%;padding:.6rem;border:0;border-radius:4px;background:#2563eb;color:#fff;cursor:pointer}
+.error{color:#dc2626;font-size:.875rem;margin-bottom:1rem}
+</style>
+</head>
+<body>
+<form method="post" action="{{.Action}}">
+<h1>{{.Title}}</h1>
+{{if .Error}}<div class="error">{{.Error}}</div>{{end}}
+<input type="hidden" name="redirect" value="{{.Redirect}}">
+<label for="username">Username</label>
+<input id="username" name="username" value="{{.User}}" autocomplete="username" required autofocus>
+<label for="password">Password</label>
+<input id="password" name="password" type="password" autocomplete="current-password" required>
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>`
+
+// FormLoginMiddleware is a browser friendly alternative of BasicAuth, it serves a login page
+// and keeps the login in a signed cookie. the login page accepts the accounts listed on any
+// attribute and the users of the credential provider. a controller which lists accounts only
+// admits a login with one of its own accounts and passwords, the others only admit users of
+// the provider.
+// can be used on Controller
+//
+//	// @FormLogin admin=secret
+type FormLoginMiddleware struct {
+	*fw.MiddlewareCtl
+	options  *FormLoginOption
+	ldap     *LdapOption
+	provider CredentialProvider
+	page     *template.Template
+	maxAge   time.Duration
+	signKey  []byte
+	attempts *auth.AttemptLimiter
+	// accounts of every controller, the login page accepts any of them
+	pairs  authPairs
+	routed bool
+	mu     sync.RWMutex
+}
+
+func (f *FormLoginMiddleware) DoInitOnce() {
+	f.LoadConfig("formLogin", f.options)
+	f.LoadConfig("ldap", f.ldap)
+	if len(f.options.Secret) < 32 {
+		panic("form login: secret must be at least 32 bytes")
+	}
+	mac := hmac.New(sha256.New, []byte(f.options.Secret))
+	mac.Write([]byte("fw-form-login"))
+	f.signKey = mac.Sum(nil)
+	d, err := time.ParseDuration(f.options.MaxAge)
+	if err != nil {
+		panic("form login: invalid max_age: " + err.Error())
+	}
+	f.maxAge = d
+	lockout, err := time.ParseDuration(f.options.Lockout)
+	if err != nil {
+		panic("form login: invalid lockout: " + err.Error())
+	}
+	f.attempts = auth.NewAttemptLimiter(f.options.MaxAttempts, lockout)
+	text := defaultLoginTemplate
+	if f.options.Template != "" {
+		bs, err := os.ReadFile(f.options.Template)
+		if err != nil {
+			panic("form login: " + err.Error())
+		}
+		text = string(bs)
+	}
+	f.page = template.Must(template.New("login").Parse(text))
+	if f.provider == nil && f.ldap.URL != "" {
+		provider, err := NewLdapProvider(f.ldap)
+		if err != nil {
+			panic(err.Error())
+		}
+		f.provider = provider
+	}
+}
+
+var errInvalidLogin = errors.New("invalid username or password")
+
+// loginCookie is the signed payload of the login cookie
+type loginCookie struct {
+	Principal *auth.Principal `json:"p"`
+	Expires   int64           `json:"e"`
+	// digest of the listed account used to log in, empty for users of the provider
+	Credential string `json:"c,omitempty"`
+}
+
+func (f *FormLoginMiddleware) sign(payload string) string {
+	mac := hmac.New(sha256.New, f.signKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// credential is the digest of a listed account, so the cookie tells which password was used
+// without containing it
+func (f *FormLoginMiddleware) credential(user, password string) string {
+	return f.sign("account " + authorizationHeader(user, password))
+}
+
+func (f *FormLoginMiddleware) encode(login *loginCookie) (string, error) {
+	login.Expires = time.Now().Add(f.maxAge).Unix()
+	bs, err := json.Marshal(login)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(bs)
+	return payload + "." + f.sign(payload), nil
+}
+
+func (f *FormLoginMiddleware) decode(value string) *loginCookie {
+	payload, sig, found := strings.Cut(value, ".")
+	if !found || !hmac.Equal([]byte(sig), []byte(f.sign(payload))) {
+		return nil
+	}
+	bs, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil
+	}
+	c := new(loginCookie)
+	if err = json.Unmarshal(bs, c); err != nil || c.Principal == nil || c.Principal.ID == "" {
+		return nil
+	}
+	if time.Now().Unix() > c.Expires {
+		return nil
+	}
+	return c
+}
+
+func (f *FormLoginMiddleware) setCookie(fctx *fasthttp.RequestCtx, value string, remove bool) {
+	cookie := fasthttp.AcquireCookie()
+	defer fasthttp.ReleaseCookie(cookie)
+	cookie.SetKey(f.options.CookieName)
+	cookie.SetValue(value)
+	cookie.SetPath(f.options.Path)
+	cookie.SetDomain(f.options.Domain)
+	cookie.SetSecure(f.options.Secure)
+	cookie.SetHTTPOnly(true)
+	switch strings.ToLower(f.options.SameSite) {
+	case "strict":
+		cookie.SetSameSite(fasthttp.CookieSameSiteStrictMode)
+	case "none":
+		cookie.SetSameSite(fasthttp.CookieSameSiteNoneMode)
+	default:
+		cookie.SetSameSite(fasthttp.CookieSameSiteLaxMode)
+	}
+	if remove {
+		cookie.SetExpire(fasthttp.CookieExpireDelete)
+	} else {
+		cookie.SetMaxAge(int(f.maxAge.Seconds()))
+	}
+	fctx.Response.Header.SetCookie(cookie)
+}
+
+// authenticate checks the accounts of every controller, then the provider
+func (f *FormLoginMiddleware) authenticate(user, password string) *loginCookie {
+	f.mu.RLock()
+	pairs := f.pairs
+	f.mu.RUnlock()
+	if found, ok := pairs.searchCredential(authorizationHeader(user, password)); ok {
+		return &loginCookie{
+			Principal:  &auth.Principal{ID: found, Method: auth.MethodForm},
+			Credential: f.credential(user, password),
+		}
+	}
+	if f.provider == nil {
+		return nil
+	}
+	principal, err := f.provider.Authenticate(user, password)
+	if err != nil {
+		return nil
+	}
+	return &loginCookie{Principal: principal}
+}
+
+// tryLogin authenticates unless the user is locked out after too many failed logins,
+// it returns how long the user has to wait then
+func (f *FormLoginMiddleware) tryLogin(user, password string) (*loginCookie, time.Duration) {
+	var login *loginCookie
+	wait, _ := f.attempts.Try(strings.ToLower(user), time.Now(), func() error {
+		if login = f.authenticate(user, password); login == nil {
+			return errInvalidLogin
+		}
+		return nil
+	})
+	if wait > 0 {
+		return nil, wait
+	}
+	return login, 0
+}
+
+// register adds the accounts of a controller to the login page, it returns the digests
+// of their credentials which the controller admits
+func (f *FormLoginMiddleware) register(accounts Accounts) map[string]string {
+	if len(accounts) == 0 {
+		if f.provider == nil {
+			panic("Empty list of authorized credentials")
+		}
+		return nil
+	}
+	pairs := processAccounts(accounts)
+	credentials := make(map[string]string, len(accounts))
+	for user, password := range accounts {
+		credentials[user] = f.credential(user, password)
+	}
+	f.mu.Lock()
+	f.pairs = append(f.pairs, pairs...)
+	f.mu.Unlock()
+	return credentials
+}
+
+// admits reports whether a controller with credentials, nil when it lists no accounts, accepts the login
+func admits(credentials map[string]string, login *loginCookie) bool {
+	if credentials == nil {
+		// only users of the provider, the accounts of other controllers are not valid here
+		return login.Credential == ""
+	}
+	expected, ok := credentials[login.Principal.ID]
+	return ok && login.Credential != "" && hmac.Equal([]byte(expected), []byte(login.Credential))
+}
+
+func (f *FormLoginMiddleware) Execute(ctx *fw.MiddlewareContext) fw.HandlerFunc {
+	accounts := make(Accounts)
+	ctx.VisitParams(func(key string, value []string) {
+		accounts[key] = value[0]
+	})
+	credentials := f.register(accounts)
+	return func(context *fw.Context) {
+		fctx := context.GetFastContext()
+		login := f.decode(conv.String(fctx.Request.Header.Cookie(f.options.CookieName)))
+		if login != nil && !admits(credentials, login) {
+			context.JSON(http.StatusForbidden, fw.H{"error": "forbidden"})
+			return
+		}
+		if login == nil {
+			if fctx.IsGet() || fctx.IsHead() {
+				target := conv.String(fctx.RequestURI())
+				fctx.Redirect(f.options.LoginPath+"?redirect="+url.QueryEscape(target), http.StatusFound)
+				return
+			}
+			context.JSON(http.StatusUnauthorized, fw.H{"error": "login required"})
+			return
+		}
+		context.Set(AuthUserKey, login.Principal.ID)
+		auth.SetPrincipal(context, login.Principal)
+		ctx.Next(context)
+	}
+}
+
+// Router registers the login page and logout routes
+func (f *FormLoginMiddleware) Router(ctx *fw.MiddlewareContext) []*fw.RouteItem {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// the middleware may be attached to many controllers, only register once
+	if f.routed {
+		return nil
+	}
+	f.routed = true
+	return []*fw.RouteItem{
+		{
+			Method:           "GET",
+			Path:             f.options.LoginPath,
+			IsHide:           true,
+			H:                f.loginPage,
+			Middleware:       f,
+			OverrideBasePath: true,
+		},
+		{
+			Method:           "POST",
+			Path:             f.options.LoginPath,
+			IsHide:           true,
+			H:                f.login,
+			Middleware:       f,
+			OverrideBasePath: true,
+		},
+		{
+			Method:           "POST",
+			Path:             f.options.LogoutPath,
+			IsHide:           true,
+			H:                f.logout,
+			Middleware:       f,
+			OverrideBasePath: true,
+		},
+	}
+}
+
+func (f *FormLoginMiddleware) render(context *fw.Context, status int, page *loginPage) {
+	page.Title = f.options.Title
+	page.Action = f.options.LoginPath
+	var buf bytes.Buffer
+	if err := f.page.Execute(&buf, page); err != nil {
+		context.JSON(http.StatusInternalServerError, fw.H{"error": err.Error()})
+		return
+	}
+	context.Data(status, "text/html; charset=utf-8", buf.Bytes())
+}
+
+func (f *FormLoginMiddleware) loginPage(context *fw.Context) {
+	redirect := auth.SafeRedirect(conv.String(context.GetFastContext().QueryArgs().Peek("redirect")))
+	f.render(context, http.StatusOK, &loginPage{Redirect: redirect})
+}
+
+func (f *FormLoginMiddleware) login(context *fw.Context) {
+	fctx := context.GetFastContext()
+	// a cross site form would log the victim in as the attacker
+	if reason, ok := auth.CheckOrigin(fctx, f.options.TrustedOrigins); !ok {
+		context.JSON(http.StatusForbidden, fw.H{"error": reason})
+		return
+	}
+	user := strings.TrimSpace(conv.String(fctx.FormValue("username")))
+	password := conv.String(fctx.FormValue("password"))
+	redirect := auth.SafeRedirect(conv.String(fctx.FormValue("redirect")))
+	login, wait := f.tryLogin(user, password)
+	if wait > 0 {
+		fctx.Response.Header.Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second)/time.Second)))
+		f.render(context, http.StatusTooManyRequests, &loginPage{
+			Redirect: redirect,
+			User:     user,
+			Error:    "Too many failed logins, try again later",
+		})
+		return
+	}
+	if login == nil {
+		f.render(context, http.StatusUnauthorized, &loginPage{
+			Redirect: redirect,
+			User:     user,
+			Error:    "Invalid username or password",
+		})
+		return
+	}
+	value, err := f.encode(login)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, fw.H{"error": err.Error()})
+		return
+	}
+	f.setCookie(fctx, value, false)
+	fctx.Redirect(redirect, http.StatusSeeOther)
+}
+
+// logout only accepts POST from our own pages, any site could link a GET
+func (f *FormLoginMiddleware) logout(context *fw.Context) {
+	if reason, ok := auth.CheckOrigin(context.GetFastContext(), f.options.TrustedOrigins); !ok {
+		context.JSON(http.StatusForbidden, fw.H{"error": reason})
+		return
+	}
+	f.setCookie(context.GetFastContext(), "", true)
+	context.GetFastContext().Redirect(f.options.LoginPath, http.StatusSeeOther)
+}
+
+// NewFormLoginMiddleware creates the middleware, pass a provider to verify credentials
+// not listed on the attribute, otherwise the LDAP provider is used when ldap.url is configured.
+func NewFormLoginMiddleware(provider ...CredentialProvider) fw.IMiddlewareCtl {
+	f := &FormLoginMiddleware{
+		MiddlewareCtl: fw.NewMiddlewareCtl(formLoginName, formLoginAttr),
+		options:       new(FormLoginOption),
+		ldap:          new(LdapOption),
+	}
+	if len(provider) > 0 {
+		f.provider = provider[0]
+	}
+	return f
+}
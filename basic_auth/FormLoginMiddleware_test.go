@@ -0,0 +1,101 @@
+package basic_auth
+
+import (
+	"errors"
+	"github.com/linxlib/fw_middlewares/auth"
+	"strings"
+	"testing"
+	"time"
+)
+
+// directory is a provider which accepts the password "ldap-" + user
+type directory struct{}
+
+func (directory) Authenticate(user, password string) (*auth.Principal, error) {
+	if password != "ldap-"+user {
+		return nil, errors.New("invalid credentials")
+	}
+	return &auth.Principal{ID: user, Method: auth.MethodLDAP}, nil
+}
+
+func newFormLogin(t *testing.T, provider ...CredentialProvider) *FormLoginMiddleware {
+	t.Helper()
+	f := NewFormLoginMiddleware(provider...).(*FormLoginMiddleware)
+	// what DoInitOnce derives from the options
+	f.signKey = []byte(strings.Repeat("k", 32))
+	f.maxAge = time.Hour
+	f.attempts = auth.NewAttemptLimiter(3, time.Minute)
+	return f
+}
+
+func TestFormLoginCredentialsPerController(t *testing.T) {
+	f := newFormLogin(t, directory{})
+	admin := f.register(Accounts{"alice": "admin-pw"})
+	reports := f.register(Accounts{"alice": "reports-pw"})
+	// lists no accounts, only users of the provider
+	directoryOnly := f.register(Accounts{})
+
+	listed, _ := f.tryLogin("alice", "admin-pw")
+	if listed == nil {
+		t.Fatal("listed account rejected")
+	}
+	if !admits(admin, listed) {
+		t.Fatal("admin rejects its own account")
+	}
+	if admits(reports, listed) {
+		t.Fatal("reports accepts the password of admin")
+	}
+	if admits(directoryOnly, listed) {
+		t.Fatal("a provider only controller accepts a listed account")
+	}
+
+	ldap, _ := f.tryLogin("alice", "ldap-alice")
+	if ldap == nil || ldap.Principal.Method != auth.MethodLDAP {
+		t.Fatalf("provider login = %+v", ldap)
+	}
+	if admits(admin, ldap) || admits(reports, ldap) {
+		t.Fatal("an ldap user with a listed name passed without the listed password")
+	}
+	if !admits(directoryOnly, ldap) {
+		t.Fatal("provider only controller rejects its user")
+	}
+}
+
+func TestFormLoginCookie(t *testing.T) {
+	f := newFormLogin(t)
+	f.register(Accounts{"alice": "pw"})
+	login, _ := f.tryLogin("alice", "pw")
+	value, err := f.encode(login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := f.decode(value)
+	if decoded == nil || decoded.Principal.ID != "alice" || decoded.Credential != login.Credential {
+		t.Fatalf("decoded %+v", decoded)
+	}
+	payload, sig, _ := strings.Cut(value, ".")
+	if f.decode(payload+"x."+sig) != nil || f.decode(payload+"."+sig+"x") != nil || f.decode(payload) != nil {
+		t.Fatal("tampered cookie accepted")
+	}
+	f.maxAge = -time.Minute
+	expired, _ := f.encode(login)
+	if f.decode(expired) != nil {
+		t.Fatal("expired cookie accepted")
+	}
+}
+
+func TestFormLoginThrottle(t *testing.T) {
+	f := newFormLogin(t)
+	f.register(Accounts{"alice": "pw"})
+	for i := 0; i < 2; i++ {
+		if login, wait := f.tryLogin("alice", "guess"); login != nil || wait != 0 {
+			t.Fatalf("guess %d = %v, %s", i+1, login, wait)
+		}
+	}
+	if _, wait := f.tryLogin("Alice", "guess"); wait != time.Minute {
+		t.Fatalf("third guess locked out for %s, want 1m", wait)
+	}
+	if login, wait := f.tryLogin("alice", "pw"); login != nil || wait == 0 {
+		t.Fatalf("the right password was accepted during the lockout: %v, %s", login, wait)
+	}
+}
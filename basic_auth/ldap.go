@@ -0,0 +1,303 @@
+package basic_auth
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/linxlib/fw_middlewares/auth"
+	"maps"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	errUserNotFound       = errors.New("ldap: user not found")
+)
+
+type LdapOption struct {
+	URL                string `yaml:"url" default:""` // ldap://host:389 or ldaps://host:636, LDAP is disabled when empty
+	StartTLS           bool   `yaml:"start_tls" default:"false"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" default:"false"`
+	BindDN             string `yaml:"bind_dn" default:""` // service account used to search users
+	BindPassword       string `yaml:"bind_password" default:""`
+	BaseDN             string `yaml:"base_dn" default:""`
+	UserFilter         string `yaml:"user_filter" default:"(uid=%s)"` // %s is replaced by the escaped user name
+	NameAttr           string `yaml:"name_attr" default:"cn"`
+	GroupAttr          string `yaml:"group_attr" default:"memberOf"`
+	// group DN => role, only listed groups become roles. when empty the CN of every group is a role
+	GroupRoles map[string]string `yaml:"group_roles"`
+	PoolSize   int               `yaml:"pool_size" default:"4"`
+	Timeout    string            `yaml:"timeout" default:"5s"`
+	CacheTTL   string            `yaml:"cache_ttl" default:"1m"` // successful binds are cached this long, 0 to disable
+}
+
+// LdapConn is the part of *ldap.Conn used by LdapProvider,
+// tests may dial an in-process stand-in instead of a real server.
+type LdapConn interface {
+	Bind(username, password string) error
+	Search(req *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+type bindCacheEntry struct {
+	principal *auth.Principal
+	expires   time.Time
+}
+
+// LdapProvider searches the user DN with a service account then binds as the user.
+type LdapProvider struct {
+	options  *LdapOption
+	timeout  time.Duration
+	cacheTTL time.Duration
+	dial     func() (LdapConn, error)
+	pool     chan LdapConn
+	mu       sync.Mutex
+	cache    map[[32]byte]bindCacheEntry
+}
+
+// NewLdapProvider creates the provider, conns are dialed from options.URL unless dial is given
+func NewLdapProvider(options *LdapOption, dial ...func() (LdapConn, error)) (*LdapProvider, error) {
+	if options.URL == "" && len(dial) == 0 {
+		return nil, errors.New("ldap: url is required")
+	}
+	p := &LdapProvider{
+		options: options,
+		cache:   make(map[[32]byte]bindCacheEntry),
+	}
+	var err error
+	if p.timeout, err = time.ParseDuration(options.Timeout); err != nil {
+		return nil, fmt.Errorf("ldap: invalid timeout: %w", err)
+	}
+	if p.cacheTTL, err = time.ParseDuration(options.CacheTTL); err != nil {
+		return nil, fmt.Errorf("ldap: invalid cache_ttl: %w", err)
+	}
+	if options.PoolSize <= 0 {
+		options.PoolSize = 1
+	}
+	p.pool = make(chan LdapConn, options.PoolSize)
+	if len(dial) > 0 {
+		p.dial = dial[0]
+	} else {
+		p.dial = p.dialURL
+	}
+	return p, nil
+}
+
+func (p *LdapProvider) dialURL() (LdapConn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: p.options.InsecureSkipVerify}
+	if u, err := url.Parse(p.options.URL); err == nil {
+		tlsConfig.ServerName = u.Hostname()
+	}
+	conn, err := ldap.DialURL(p.options.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: p.timeout}),
+		ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(p.timeout)
+	if p.options.StartTLS {
+		if err = conn.StartTLS(tlsConfig); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// get takes a conn bound as the service account from the pool or dials a new one
+func (p *LdapProvider) get() (conn LdapConn, pooled bool, err error) {
+	select {
+	case conn = <-p.pool:
+		return conn, true, nil
+	default:
+	}
+	if conn, err = p.dial(); err != nil {
+		return nil, false, err
+	}
+	if err = p.bindService(conn); err != nil {
+		_ = conn.Close()
+		return nil, false, err
+	}
+	return conn, false, nil
+}
+
+func (p *LdapProvider) bindService(conn LdapConn) error {
+	if p.options.BindDN == "" {
+		return nil
+	}
+	return conn.Bind(p.options.BindDN, p.options.BindPassword)
+}
+
+// put returns a healthy conn to the pool, closes it when the pool is full
+func (p *LdapProvider) put(conn LdapConn) {
+	select {
+	case p.pool <- conn:
+	default:
+		_ = conn.Close()
+	}
+}
+
+func cacheKey(user, password string) [32]byte {
+	return sha256.Sum256([]byte(user + "\x00" + password))
+}
+
+func (p *LdapProvider) cached(key [32]byte) *auth.Principal {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.cache[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(e.expires) {
+		delete(p.cache, key)
+		return nil
+	}
+	return copyPrincipal(e.principal)
+}
+
+func (p *LdapProvider) remember(key [32]byte, principal *auth.Principal) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for k, e := range p.cache {
+		if now.After(e.expires) {
+			delete(p.cache, k)
+		}
+	}
+	p.cache[key] = bindCacheEntry{principal: copyPrincipal(principal), expires: now.Add(p.cacheTTL)}
+}
+
+// copyPrincipal keeps cached principals safe from later SetAttribute calls
+func copyPrincipal(principal *auth.Principal) *auth.Principal {
+	c := *principal
+	c.Roles = slices.Clone(principal.Roles)
+	c.Attributes = maps.Clone(principal.Attributes)
+	return &c
+}
+
+// roles maps group DNs to roles
+func (p *LdapProvider) roles(groups []string) []string {
+	var roles []string
+	for _, group := range groups {
+		if len(p.options.GroupRoles) > 0 {
+			for dn, role := range p.options.GroupRoles {
+				if strings.EqualFold(dn, group) && !slices.Contains(roles, role) {
+					roles = append(roles, role)
+				}
+			}
+			continue
+		}
+		parsed, err := ldap.ParseDN(group)
+		if err != nil || len(parsed.RDNs) == 0 {
+			continue
+		}
+		for _, attr := range parsed.RDNs[0].Attributes {
+			if strings.EqualFold(attr.Type, "cn") && !slices.Contains(roles, attr.Value) {
+				roles = append(roles, attr.Value)
+			}
+		}
+	}
+	return roles
+}
+
+// Authenticate searches the user with the service account and binds as the user
+func (p *LdapProvider) Authenticate(user, password string) (*auth.Principal, error) {
+	// an empty password is an unauthenticated bind, which most servers accept
+	if user == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	key := cacheKey(user, password)
+	if p.cacheTTL > 0 {
+		if principal := p.cached(key); principal != nil {
+			return principal, nil
+		}
+	}
+	var conn LdapConn
+	var principal *auth.Principal
+	for {
+		c, pooled, err := p.get()
+		if err != nil {
+			return nil, err
+		}
+		principal, err = p.authenticate(c, user, password)
+		if err == nil || errors.Is(err, ErrInvalidCredentials) || errors.Is(err, errUserNotFound) {
+			conn = c
+			break
+		}
+		// the conn may be broken, do not put it back. a pooled conn may just have been
+		// closed by the server while idle, retry with the next one
+		_ = c.Close()
+		if !pooled {
+			return nil, err
+		}
+	}
+	// the user bind changed the identity of the conn, switch back before pooling it
+	if p.options.BindDN == "" || p.bindService(conn) != nil {
+		_ = conn.Close()
+	} else {
+		p.put(conn)
+	}
+	if principal == nil {
+		return nil, ErrInvalidCredentials
+	}
+	if p.cacheTTL > 0 {
+		p.remember(key, principal)
+	}
+	return principal, nil
+}
+
+func (p *LdapProvider) authenticate(conn LdapConn, user, password string) (*auth.Principal, error) {
+	req := ldap.NewSearchRequest(
+		p.options.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(p.timeout.Seconds()), false,
+		fmt.Sprintf(p.options.UserFilter, ldap.EscapeFilter(user)),
+		[]string{p.options.NameAttr, p.options.GroupAttr},
+		nil,
+	)
+	result, err := conn.Search(req)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return nil, errUserNotFound
+		}
+		return nil, err
+	}
+	// more than one entry means the filter is ambiguous, refuse rather than pick one
+	if len(result.Entries) != 1 {
+		return nil, errUserNotFound
+	}
+	entry := result.Entries[0]
+	if err = conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	principal := &auth.Principal{
+		ID:     user,
+		Name:   entry.GetAttributeValue(p.options.NameAttr),
+		Roles:  p.roles(entry.GetAttributeValues(p.options.GroupAttr)),
+		Method: auth.MethodLDAP,
+	}
+	principal.SetAttribute("dn", entry.DN)
+	return principal, nil
+}
+
+// Close closes pooled conns
+func (p *LdapProvider) Close() {
+	for {
+		select {
+		case conn := <-p.pool:
+			_ = conn.Close()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package basic_auth
+
+import (
+	"errors"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/linxlib/fw_middlewares/auth"
+	"slices"
+	"sync"
+	"testing"
+)
+
+const (
+	serviceDN       = "cn=svc,dc=example,dc=org"
+	servicePassword = "svc-pw"
+)
+
+type ldapEntry struct {
+	dn, uid, cn, password string
+	groups                []string
+}
+
+// ldapServer is an in-process stand-in of a directory
+type ldapServer struct {
+	entries  []ldapEntry
+	mu       sync.Mutex
+	conns    []*ldapConn
+	dials    int
+	searches int
+}
+
+func newLdapServer() *ldapServer {
+	return &ldapServer{entries: []ldapEntry{
+		{
+			dn: "uid=alice,ou=people,dc=example,dc=org", uid: "alice", cn: "Alice", password: "alice-pw",
+			groups: []string{"cn=admins,ou=groups,dc=example,dc=org", "cn=dev,ou=groups,dc=example,dc=org"},
+		},
+		{dn: "uid=bob,ou=people,dc=example,dc=org", uid: "bob", cn: "Bob", password: "bob-pw"},
+		// two entries for one uid, the filter is ambiguous
+		{dn: "uid=carol,ou=people,dc=example,dc=org", uid: "carol", password: "carol-pw"},
+		{dn: "uid=carol,ou=contractors,dc=example,dc=org", uid: "carol", password: "carol-pw"},
+	}}
+}
+
+func (s *ldapServer) dial() (LdapConn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dials++
+	conn := &ldapConn{server: s}
+	s.conns = append(s.conns, conn)
+	return conn, nil
+}
+
+// drop breaks every conn dialed so far, like a server closing idle conns
+func (s *ldapServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.broken = true
+	}
+}
+
+func (s *ldapServer) stats() (dials, searches int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dials, s.searches
+}
+
+type ldapConn struct {
+	server *ldapServer
+	bound  string
+	broken bool
+	closed bool
+}
+
+var errConnBroken = ldap.NewError(ldap.ErrorNetwork, errors.New("connection closed"))
+
+func (c *ldapConn) Bind(username, password string) error {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	if c.broken || c.closed {
+		return errConnBroken
+	}
+	if username == serviceDN && password == servicePassword {
+		c.bound = username
+		return nil
+	}
+	for _, e := range c.server.entries {
+		if e.dn == username && e.password == password && password != "" {
+			c.bound = username
+			return nil
+		}
+	}
+	c.bound = ""
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+}
+
+func (c *ldapConn) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	if c.broken || c.closed {
+		return nil, errConnBroken
+	}
+	if c.bound != serviceDN {
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("search needs the service account"))
+	}
+	c.server.searches++
+	result := &ldap.SearchResult{}
+	for _, e := range c.server.entries {
+		if req.Filter == "(uid="+ldap.EscapeFilter(e.uid)+")" {
+			result.Entries = append(result.Entries, ldap.NewEntry(e.dn, map[string][]string{
+				"cn":       {e.cn},
+				"memberOf": e.groups,
+			}))
+		}
+	}
+	return result, nil
+}
+
+func (c *ldapConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func newTestLdapProvider(t *testing.T, server *ldapServer, cacheTTL string, groupRoles map[string]string) *LdapProvider {
+	t.Helper()
+	p, err := NewLdapProvider(&LdapOption{
+		BindDN:       serviceDN,
+		BindPassword: servicePassword,
+		BaseDN:       "dc=example,dc=org",
+		UserFilter:   "(uid=%s)",
+		NameAttr:     "cn",
+		GroupAttr:    "memberOf",
+		GroupRoles:   groupRoles,
+		PoolSize:     2,
+		Timeout:      "1s",
+		CacheTTL:     cacheTTL,
+	}, server.dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestLdapAuthenticate(t *testing.T) {
+	p := newTestLdapProvider(t, newLdapServer(), "0s", nil)
+	principal, err := p.Authenticate("alice", "alice-pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal.ID != "alice" || principal.Name != "Alice" || principal.Method != auth.MethodLDAP {
+		t.Fatalf("principal = %+v", principal)
+	}
+	if dn, _ := principal.Attributes["dn"].(string); dn != "uid=alice,ou=people,dc=example,dc=org" {
+		t.Fatalf("dn = %v", principal.Attributes["dn"])
+	}
+	// without group_roles the cn of every group is a role
+	if !slices.Equal(principal.Roles, []string{"admins", "dev"}) {
+		t.Fatalf("roles = %v", principal.Roles)
+	}
+	for _, c := range []struct{ name, user, password string }{
+		{"wrong password", "alice", "bob-pw"},
+		{"unknown user", "dave", "dave-pw"},
+		{"ambiguous filter", "carol", "carol-pw"},
+		{"filter injection", "*", "alice-pw"},
+		{"filter injection with or", "alice)(|(uid=*", "alice-pw"},
+	} {
+		if _, err = p.Authenticate(c.user, c.password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%s: err = %v, want ErrInvalidCredentials", c.name, err)
+		}
+	}
+}
+
+func TestLdapRejectsEmptyPassword(t *testing.T) {
+	server := newLdapServer()
+	p := newTestLdapProvider(t, server, "0s", nil)
+	if _, err := p.Authenticate("alice", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("err = %v", err)
+	}
+	// refused before the server could treat it as an unauthenticated bind
+	if dials, _ := server.stats(); dials != 0 {
+		t.Fatalf("%d dials for an empty password", dials)
+	}
+}
+
+func TestLdapGroupRoles(t *testing.T) {
+	p := newTestLdapProvider(t, newLdapServer(), "0s", map[string]string{
+		"CN=Admins,OU=Groups,DC=example,DC=org": "admin",
+		"cn=ops,ou=groups,dc=example,dc=org":    "ops",
+	})
+	principal, err := p.Authenticate("alice", "alice-pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only listed groups become roles, compared case insensitively
+	if !slices.Equal(principal.Roles, []string{"admin"}) {
+		t.Fatalf("roles = %v", principal.Roles)
+	}
+}
+
+func TestLdapBindCache(t *testing.T) {
+	server := newLdapServer()
+	p := newTestLdapProvider(t, server, "1m", nil)
+	first, err := p.Authenticate("alice", "alice-pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.SetAttribute("mfa", "totp")
+	second, err := p.Authenticate("alice", "alice-pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, searches := server.stats(); searches != 1 {
+		t.Fatalf("%d searches, the second login was not cached", searches)
+	}
+	if _, ok := second.Attributes["mfa"]; ok {
+		t.Fatal("the cached principal was changed through an earlier copy")
+	}
+	// a different password is not answered from the cache
+	if _, err = p.Authenticate("alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("wrong password = %v", err)
+	}
+	if _, searches := server.stats(); searches != 2 {
+		t.Fatalf("%d searches, want 2", searches)
+	}
+}
+
+func TestLdapPoolRetry(t *testing.T) {
+	server := newLdapServer()
+	p := newTestLdapProvider(t, server, "0s", nil)
+	if _, err := p.Authenticate("alice", "alice-pw"); err != nil {
+		t.Fatal(err)
+	}
+	// the conn is rebound as the service account and reused
+	if _, err := p.Authenticate("bob", "bob-pw"); err != nil {
+		t.Fatal(err)
+	}
+	if dials, _ := server.stats(); dials != 1 {
+		t.Fatalf("%d dials, the pooled conn was not reused", dials)
+	}
+	// the server closed the idle conn, the next login retries with a new one
+	server.drop()
+	if _, err := p.Authenticate("alice", "alice-pw"); err != nil {
+		t.Fatalf("retry after a dropped conn: %v", err)
+	}
+	if dials, _ := server.stats(); dials != 2 {
+		t.Fatalf("%d dials, want 2", dials)
+	}
+}
+
+func TestCheckerStampsMethod(t *testing.T) {
+	p := newTestLdapProvider(t, newLdapServer(), "1m", nil)
+	checker := NewChecker(Accounts{"admin": "secret"}, p)
+	for _, method := range []string{auth.MethodBasic, auth.MethodProxyBasic} {
+		principal, ok := checker.Check(authorizationHeader("alice", "alice-pw"), method)
+		if !ok || principal.Method != method || principal.ID != "alice" {
+			t.Fatalf("ldap user with %s = %+v, %v", method, principal, ok)
+		}
+		principal, ok = checker.Check(authorizationHeader("admin", "secret"), method)
+		if !ok || principal.Method != method {
+			t.Fatalf("listed account with %s = %+v, %v", method, principal, ok)
+		}
+	}
+	if _, ok := checker.Check(authorizationHeader("alice", "wrong"), auth.MethodBasic); ok {
+		t.Fatal("wrong password accepted")
+	}
+}
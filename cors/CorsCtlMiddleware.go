@@ -0,0 +1,92 @@
+package cors
+
+import (
+	"github.com/linxlib/fw"
+	"sync"
+)
+
+var _ fw.IMiddlewareCtl = (*CorsCtlMiddleware)(nil)
+
+const (
+	corsCtlAttr = "Cors"
+	corsCtlName = "Cors"
+)
+
+// CorsCtlMiddleware overrides the global cors policy of a controller or method, params which are
+// not given keep the global value. preflight requests are answered with the policy of the route they
+// target: a method registers a preflight route on its own path, a controller one for every path under it.
+// can be used on Controller or Method
+//
+//	// @Cors origins=*
+//	// @Cors origins=https://admin.example.com credentials=true methods=GET,POST headers=Authorization,Content-Type max_age=1h
+type CorsCtlMiddleware struct {
+	*fw.MiddlewareCtl
+	global *CorsMiddleware
+	// global is not registered as a middleware, init it here
+	owned  bool
+	routed map[string]bool // policy keys with a preflight route
+	mu     sync.Mutex
+}
+
+func (c *CorsCtlMiddleware) DoInitOnce() {
+	if c.owned {
+		c.global.DoInitOnce()
+	}
+}
+
+func (c *CorsCtlMiddleware) Execute(ctx *fw.MiddlewareContext) fw.HandlerFunc {
+	p := c.global.override(policyKey(ctx.ControllerName, ctx.MethodName), parsePolicyParams(ctx))
+	return func(context *fw.Context) {
+		// the global middleware already applied this policy
+		if _, ok := context.Get(appliedKey); ok {
+			ctx.Next(context)
+			return
+		}
+		if p.current.Load().applyCors(context) {
+			ctx.Next(context)
+		}
+	}
+}
+
+// Router answers preflight requests of a method on its path and those of a controller under its path,
+// the policy is built from the params of the attribute on that method or controller only
+func (c *CorsCtlMiddleware) Router(ctx *fw.MiddlewareContext) []*fw.RouteItem {
+	key := policyKey(ctx.ControllerName, ctx.MethodName)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.routed[key] {
+		return nil
+	}
+	c.routed[key] = true
+	p := c.global.override(key, parsePolicyParams(ctx))
+	// the path of a method route is the route itself, the router prefers it over the catch-all
+	path := ""
+	if ctx.MethodName == "" {
+		path = "/{all:*}"
+	}
+	return []*fw.RouteItem{{
+		Method: "OPTIONS",
+		Path:   path,
+		IsHide: true,
+		H: func(context *fw.Context) {
+			p.current.Load().applyCors(context)
+		},
+		Middleware: c,
+	}}
+}
+
+// NewCorsCtlMiddleware creates the attribute variant, params override the policy of global.
+// without global the overrides are applied on DefaultConfig.
+func NewCorsCtlMiddleware(global ...*CorsMiddleware) fw.IMiddlewareCtl {
+	c := &CorsCtlMiddleware{
+		MiddlewareCtl: fw.NewMiddlewareCtl(corsCtlName, corsCtlAttr),
+		routed:        make(map[string]bool),
+	}
+	if len(global) > 0 {
+		c.global = global[0]
+	} else {
+		c.global = NewDefaultCorsMiddleware()
+		c.owned = true
+	}
+	return c
+}
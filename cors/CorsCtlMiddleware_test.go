@@ -0,0 +1,41 @@
+package cors
+
+import (
+	"github.com/linxlib/fw"
+	"testing"
+)
+
+func TestCtlRouterRegistersPreflightPerTarget(t *testing.T) {
+	c := NewCorsCtlMiddleware().(*CorsCtlMiddleware)
+	method := c.Router(&fw.MiddlewareContext{ControllerName: "Orders", MethodName: "Create"})
+	if len(method) != 1 || method[0].Method != "OPTIONS" || method[0].Path != "" {
+		t.Fatalf("method preflight route = %+v, want OPTIONS on the method path", method)
+	}
+	controller := c.Router(&fw.MiddlewareContext{ControllerName: "Orders"})
+	if len(controller) != 1 || controller[0].Path != "/{all:*}" {
+		t.Fatalf("controller preflight route = %+v, want OPTIONS /{all:*}", controller)
+	}
+	// a second method of the controller does not replace the controller policy
+	if routes := c.Router(&fw.MiddlewareContext{ControllerName: "Orders", MethodName: "Delete"}); len(routes) != 1 {
+		t.Fatalf("second method got %d routes", len(routes))
+	}
+	if routes := c.Router(&fw.MiddlewareContext{ControllerName: "Orders", MethodName: "Create"}); routes != nil {
+		t.Fatalf("method routed twice: %+v", routes)
+	}
+	for _, key := range []string{policyKey("Orders", ""), policyKey("Orders", "Create"), policyKey("Orders", "Delete")} {
+		if _, ok := c.global.policies[key]; !ok {
+			t.Errorf("no policy for %s", key)
+		}
+	}
+}
+
+func TestOverrideIsSharedByExecuteAndRouter(t *testing.T) {
+	c := NewCorsCtlMiddleware().(*CorsCtlMiddleware)
+	ctx := &fw.MiddlewareContext{ControllerName: "Orders", MethodName: "Create"}
+	c.Router(ctx)
+	p := c.global.policies[policyKey("Orders", "Create")]
+	c.Execute(ctx)
+	if c.global.policies[policyKey("Orders", "Create")] != p {
+		t.Fatal("Execute registered a second policy for the route, reloads would miss the first one")
+	}
+}
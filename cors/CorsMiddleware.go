@@ -1,27 +1,206 @@
 package cors
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/linxlib/conv"
 	"github.com/linxlib/fw"
+	"github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"net"
 	"net/http"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var _ fw.IMiddlewareGlobal = (*CorsMiddleware)(nil)
 
+// CorsMiddleware applies the cors policy to every request and answers preflight requests.
+// the policy comes from a Config or from the cors section of the config, see NewYamlCorsMiddleware.
 type CorsMiddleware struct {
 	*fw.MiddlewareGlobal
-	config Config
+	Logger  *logrus.Logger `inject:""`
+	config  Config
+	options *CorsOption // nil when built from a Config
+	// current is swapped when the watched file changes
+	current atomic.Pointer[cors]
+	// controller and method overrides registered by CorsCtlMiddleware
+	policies map[string]*policy
+	mu       sync.RWMutex
+	admin    *CorsAdminOption
+	rejects  *rejectCounter
+	// closed by Close to stop watching the file
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func (c *CorsMiddleware) DoInitOnce() {
+	c.LoadConfig("corsAdmin", c.admin)
+	if c.options == nil {
+		c.current.Store(c.build(c.config))
+		return
+	}
+	c.LoadConfig("cors", c.options)
+	options := c.options
+	// stat before reading, a change while starting up is reloaded by watch
+	version, _ := statFile(options.File)
+	if options.File != "" {
+		merged, err := options.overlay(options.File)
+		if err != nil {
+			panic("cors: " + err.Error())
+		}
+		options = merged
+	}
+	config, err := options.Config()
+	if err != nil {
+		panic(err.Error())
+	}
+	c.config = config
+	c.current.Store(c.build(config))
+	if c.options.File != "" {
+		interval, err := time.ParseDuration(c.options.WatchInterval)
+		if err != nil {
+			panic("cors: invalid watch_interval: " + err.Error())
+		}
+		go c.watch(c.options.File, version, interval)
+	}
+}
+
+// watch polls the file and reloads the policy when it changes, an invalid file keeps the running policy
+// last is the version of the file the running policy was read from.
+func (c *CorsMiddleware) watch(file string, last fileVersion, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.stop:
+			return
+		}
+		version, err := statFile(file)
+		if err != nil || version == last {
+			continue
+		}
+		last = version
+		if err = c.reload(file); err != nil {
+			c.logf(logrus.ErrorLevel, "cors: reload %s failed, keep the running policy: %s", file, err)
+			continue
+		}
+		c.logf(logrus.InfoLevel, "cors: reloaded %s", file)
+	}
+}
+
+// Close stops watching cors.file, the running policy is kept
+func (c *CorsMiddleware) Close() error {
+	c.stopOnce.Do(func() { close(c.stop) })
+	return nil
+}
+
+func (c *CorsMiddleware) reload(file string) error {
+	options, err := c.options.overlay(file)
+	if err != nil {
+		return err
+	}
+	config, err := options.Config()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// build every override first, a config which breaks one of them is not applied at all
+	overrides := make(map[*policy]Config, len(c.policies))
+	for key, p := range c.policies {
+		if !p.overridden {
+			continue
+		}
+		overridden, err := p.params.apply(config)
+		if err != nil {
+			return fmt.Errorf("policy of %s: %w", key, err)
+		}
+		overrides[p] = overridden
+	}
+	c.config = config
+	c.current.Store(c.build(config))
+	for p, overridden := range overrides {
+		p.current.Store(c.build(overridden))
+	}
+	return nil
+}
+
+// build creates the policy and reports its rejects to the middleware
+func (c *CorsMiddleware) build(config Config) *cors {
+	cors := newCors(config)
+	cors.report = c.report
+	if cors.originSource != nil {
+		cors.originSource.handleErrors(func(err error) {
+			c.logf(logrus.WarnLevel, "cors: origin source failed, keep the last origins: %s", err)
+		})
+	}
+	return cors
+}
+
+// override registers the policy of a controller or method, it panics when the params are invalid.
+// Execute and Router of the same attribute share the policy registered first.
+func (c *CorsMiddleware) override(key string, params policyParams) *policy {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.policies[key]
+	if ok && p.overridden {
+		return p
+	}
+	config, err := params.apply(c.config)
+	if err != nil {
+		panic(key + ": " + err.Error())
+	}
+	if !ok {
+		p = &policy{}
+		c.policies[key] = p
+	}
+	// a placeholder handed out by route already is filled in, the route sees the override
+	p.params = params
+	p.overridden = true
+	p.current.Store(c.build(config))
+	return p
+}
+
+// route returns the policy of a route, a placeholder following the global policy until
+// CorsCtlMiddleware overrides it. the global middleware may run before the override is registered.
+func (c *CorsMiddleware) route(key string) *policy {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.policies[key]
+	if !ok {
+		p = &policy{}
+		c.policies[key] = p
+	}
+	return p
+}
+
+// load returns the override of the route or the global policy
+func (c *CorsMiddleware) load(p *policy) *cors {
+	if cors := p.current.Load(); cors != nil {
+		return cors
+	}
+	return c.current.Load()
+}
+
+func (c *CorsMiddleware) logf(level logrus.Level, format string, args ...any) {
+	if c.Logger != nil {
+		c.Logger.Logf(level, format, args...)
+	}
 }
 
 func (c *CorsMiddleware) Execute(ctx *fw.MiddlewareContext) fw.HandlerFunc {
-	cors := newCors(c.config)
+	p := c.route(policyKey(ctx.ControllerName, ctx.MethodName))
 	return func(context *fw.Context) {
-		if cors.applyCors(context) {
+		if c.load(p).applyCors(context) {
+			context.Set(appliedKey, true)
 			ctx.Next(context)
 		}
 	}
@@ -31,6 +210,10 @@ func NewDefaultCorsMiddleware() *CorsMiddleware {
 	return &CorsMiddleware{
 		MiddlewareGlobal: fw.NewMiddlewareGlobal("CorsMiddleware"),
 		config:           DefaultConfig(),
+		policies:         make(map[string]*policy),
+		admin:            new(CorsAdminOption),
+		rejects:          newRejectCounter(),
+		stop:             make(chan struct{}),
 	}
 }
 
@@ -38,19 +221,38 @@ func NewCorsMiddleware(config Config) *CorsMiddleware {
 	return &CorsMiddleware{
 		MiddlewareGlobal: fw.NewMiddlewareGlobal("CorsMiddleware"),
 		config:           config,
+		policies:         make(map[string]*policy),
+		admin:            new(CorsAdminOption),
+		rejects:          newRejectCounter(),
+		stop:             make(chan struct{}),
+	}
+}
+
+// NewYamlCorsMiddleware creates the middleware configured by the cors section,
+// the policy is hot reloaded when cors.file is set.
+func NewYamlCorsMiddleware() *CorsMiddleware {
+	return &CorsMiddleware{
+		MiddlewareGlobal: fw.NewMiddlewareGlobal("CorsMiddleware"),
+		options:          new(CorsOption),
+		policies:         make(map[string]*policy),
+		admin:            new(CorsAdminOption),
+		rejects:          newRejectCounter(),
+		stop:             make(chan struct{}),
 	}
 }
+
+// Router answers preflight requests of routes without an override, routes overridden by CorsCtlMiddleware
+// register their own preflight route which the router prefers over the catch-all
 func (c *CorsMiddleware) Router(ctx *fw.MiddlewareContext) []*fw.RouteItem {
-	cors := newCors(c.config)
-	return []*fw.RouteItem{&fw.RouteItem{
+	return append([]*fw.RouteItem{&fw.RouteItem{
 		Method: "OPTIONS",
 		Path:   "/{all:*}",
 		IsHide: false,
 		H: func(context *fw.Context) {
-			cors.applyCors(context)
+			c.current.Load().applyCors(context)
 		},
 		Middleware: c,
-	}}
+	}}, c.adminRoutes()...)
 }
 
 type converter func(string) string
@@ -81,7 +283,8 @@ func generatePreflightHeaders(c Config) http.Header {
 		value := strings.Join(allowMethods, ",")
 		headers.Set("Access-Control-Allow-Methods", value)
 	}
-	if len(c.AllowHeaders) > 0 {
+	// reflected headers are set per request
+	if len(c.AllowHeaders) > 0 && !c.ReflectRequestHeaders {
 		allowHeaders := convert(normalize(c.AllowHeaders), http.CanonicalHeaderKey)
 		value := strings.Join(allowHeaders, ",")
 		headers.Set("Access-Control-Allow-Headers", value)
@@ -91,12 +294,11 @@ func generatePreflightHeaders(c Config) http.Header {
 		headers.Set("Access-Control-Max-Age", value)
 	}
 
-	if c.AllowPrivateNetwork {
-		headers.Set("Access-Control-Allow-Private-Network", "true")
-	}
-
 	if c.AllowAllOrigins {
 		headers.Set("Access-Control-Allow-Origin", "*")
+		if c.ReflectRequestHeaders {
+			headers.Add("Vary", "Access-Control-Request-Headers")
+		}
 	} else {
 		// Always set Vary headers
 		// see https://github.com/rs/cors/issues/10,
@@ -134,16 +336,84 @@ func convert(s []string, c converter) []string {
 	return out
 }
 
+// header names and values set on every cors request, fasthttp copies them into the response
+var (
+	headerOrigin                = []byte("Origin")
+	headerRequestMethod         = []byte("Access-Control-Request-Method")
+	headerRequestHeaders        = []byte("Access-Control-Request-Headers")
+	headerRequestPrivateNetwork = []byte("Access-Control-Request-Private-Network")
+	headerAllowOrigin           = []byte("Access-Control-Allow-Origin")
+	headerAllowHeaders          = []byte("Access-Control-Allow-Headers")
+	headerAllowPrivateNetwork   = []byte("Access-Control-Allow-Private-Network")
+	valueTrue                   = []byte("true")
+)
+
+// headerKV is a precomputed response header
+type headerKV struct {
+	key   []byte
+	value []byte
+}
+
+// precompute joins the values of every header once, sorted by name so the order of the response is stable
+func precompute(headers http.Header) []headerKV {
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	kvs := make([]headerKV, 0, len(keys))
+	for _, key := range keys {
+		kvs = append(kvs, headerKV{key: []byte(key), value: []byte(strings.Join(headers[key], ", "))})
+	}
+	return kvs
+}
+
 type cors struct {
 	allowAllOrigins            bool
 	allowCredentials           bool
 	allowOriginFunc            func(string) bool
 	allowOriginWithContextFunc func(*fw.Context, string) bool
-	allowOrigins               []string
-	normalHeaders              http.Header
-	preflightHeaders           http.Header
-	wildcardOrigins            [][]string
-	optionsResponseStatusCode  int
+	exactOrigins               map[string]string // normalized by normalizeOrigin, the value is the rule
+	originPatterns             []*originPattern
+	originRegexps              []*regexp.Regexp
+	originRegexRules           []string // rule of each regexp, built once for the debug header
+	originSource               *CachedOriginSource
+	trustedProxies             []*net.IPNet
+	allowNullOrigin            bool
+	// diagnostics
+	debug                     bool
+	report                    func(event *rejectEvent)
+	normalHeaders             []headerKV
+	preflightHeaders          []headerKV
+	optionsResponseStatusCode int
+	// preflight validation
+	allowMethods          map[string]bool // upper case
+	allowHeaders          map[string]bool // lower case
+	allowAnyHeader        bool
+	reflectRequestHeaders bool
+	allowPrivateNetwork   bool
+}
+
+// safelisted methods and headers never need to be allowed, see https://fetch.spec.whatwg.org/#cors-safelisted-method
+var (
+	safelistedMethods = map[string]bool{"GET": true, "HEAD": true, "POST": true}
+	safelistedHeaders = map[string]bool{"accept": true, "accept-language": true, "content-language": true}
+)
+
+func regexRules(exprs []string) []string {
+	rules := make([]string, len(exprs))
+	for i, expr := range exprs {
+		rules[i] = "regex " + expr
+	}
+	return rules
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
 }
 
 var (
@@ -181,110 +451,228 @@ func newCors(config Config) *cors {
 		config.OptionsResponseStatusCode = http.StatusNoContent
 	}
 
+	exactOrigins, patterns, regexps := config.parseOrigins()
+	allowHeaders := normalize(config.AllowHeaders)
+	trustedProxies, _ := parseTrustedProxies(config.TrustedProxies)
+	var originSource *CachedOriginSource
+	if config.AllowOriginSource != nil {
+		cached, ok := config.AllowOriginSource.(*CachedOriginSource)
+		if !ok {
+			cached = NewCachedOriginSource(config.AllowOriginSource, DefaultOriginSourceTTL)
+		}
+		originSource = cached
+	}
 	return &cors{
+		originSource:               originSource,
+		trustedProxies:             trustedProxies,
+		allowNullOrigin:            config.AllowNullOrigin,
+		allowMethods:               toSet(convert(normalize(config.AllowMethods), strings.ToUpper)),
+		allowHeaders:               toSet(allowHeaders),
+		allowAnyHeader:             slices.Contains(allowHeaders, "*"),
+		reflectRequestHeaders:      config.ReflectRequestHeaders,
+		allowPrivateNetwork:        config.AllowPrivateNetwork,
+		debug:                      config.Debug,
 		allowOriginFunc:            config.AllowOriginFunc,
 		allowOriginWithContextFunc: config.AllowOriginWithContextFunc,
 		allowAllOrigins:            config.AllowAllOrigins,
 		allowCredentials:           config.AllowCredentials,
-		allowOrigins:               normalize(config.AllowOrigins),
-		normalHeaders:              generateNormalHeaders(config),
-		preflightHeaders:           generatePreflightHeaders(config),
-		wildcardOrigins:            config.parseWildcardRules(),
+		exactOrigins:               exactOrigins,
+		originPatterns:             patterns,
+		originRegexps:              regexps,
+		originRegexRules:           regexRules(config.AllowOriginRegex),
+		normalHeaders:              precompute(generateNormalHeaders(config)),
+		preflightHeaders:           precompute(generatePreflightHeaders(config)),
 		optionsResponseStatusCode:  config.OptionsResponseStatusCode,
 	}
 }
 
-func (cors *cors) applyCors(c *fw.Context) (v bool) {
-	origin := conv.String(c.GetFastContext().Request.Header.Peek("Origin"))
+func (cors *cors) applyCors(c *fw.Context) bool {
+	return cors.apply(c.GetFastContext(), c)
+}
+
+// apply adds the cors headers to the response, it returns false when the request is rejected or answered
+// as a preflight. c is only passed to AllowOriginWithContextFunc.
+func (cors *cors) apply(fctx *fasthttp.RequestCtx, c *fw.Context) (v bool) {
+	originBytes := fctx.Request.Header.PeekBytes(headerOrigin)
+	// the request buffer outlives the call, no need to copy
+	origin := conv.UnsafeBytesToStr(originBytes)
 	if len(origin) == 0 {
 		// request is not a CORS request
 		v = true
 		return
 	}
-	host := conv.String(c.GetFastContext().Host())
-
-	if origin == "http://"+host || origin == "https://"+host {
+	if cors.sameOrigin(fctx, origin) {
 		// request is not a CORS request but have origin header.
 		// for example, use fetch api
 		v = true
 		return
 	}
 
-	if !cors.isOriginValid(c, origin) {
-		c.GetFastContext().SetStatusCode(http.StatusForbidden)
+	rule := cors.isOriginValid(fctx, c, origin)
+	if rule == "" {
+		cors.reject(fctx, origin, "origin not allowed", rule)
 		v = false
 		return
 	}
 
-	if conv.String(c.GetFastContext().Method()) == "OPTIONS" {
-		cors.handlePreflight(c)
+	// an OPTIONS request without Access-Control-Request-Method is not a preflight
+	if fctx.IsOptions() && len(fctx.Request.Header.PeekBytes(headerRequestMethod)) > 0 {
+		if reason := cors.validatePreflight(fctx); reason != "" {
+			cors.reject(fctx, origin, reason, rule)
+			v = false
+			return
+		}
+		cors.handlePreflight(fctx)
 		defer func() {
-			c.GetFastContext().SetStatusCode(cors.optionsResponseStatusCode)
+			fctx.SetStatusCode(cors.optionsResponseStatusCode)
 			v = false
 		}()
 	} else {
-		cors.handleNormal(c)
+		cors.handleNormal(fctx)
 		v = true
 	}
 
 	if !cors.allowAllOrigins {
-		c.GetFastContext().Response.Header.Set("Access-Control-Allow-Origin", origin)
+		fctx.Response.Header.SetBytesKV(headerAllowOrigin, originBytes)
+	}
+	if cors.debug {
+		fctx.Response.Header.Set(debugHeader, "allowed by "+rule)
 	}
 	return
 }
 
-func (cors *cors) validateWildcardOrigin(origin string) bool {
-	for _, w := range cors.wildcardOrigins {
-		if w[0] == "*" && strings.HasSuffix(origin, w[1]) {
-			return true
-		}
-		if w[1] == "*" && strings.HasPrefix(origin, w[0]) {
-			return true
-		}
-		if strings.HasPrefix(origin, w[0]) && strings.HasSuffix(origin, w[1]) {
-			return true
-		}
+// reject answers 403, explains why in debug mode and reports the event
+func (cors *cors) reject(fctx *fasthttp.RequestCtx, origin string, reason string, rule string) {
+	fctx.SetStatusCode(http.StatusForbidden)
+	if cors.debug {
+		fctx.Response.Header.Set(debugHeader, reason)
+	}
+	if cors.report != nil {
+		cors.report(&rejectEvent{
+			Origin:         origin,
+			Method:         conv.String(fctx.Method()),
+			Path:           conv.String(fctx.Path()),
+			RequestMethod:  conv.String(fctx.Request.Header.Peek("Access-Control-Request-Method")),
+			RequestHeaders: conv.String(fctx.Request.Header.Peek("Access-Control-Request-Headers")),
+			Reason:         reason,
+			Rule:           rule,
+			Debug:          cors.debug,
+		})
 	}
-
-	return false
 }
 
-func (cors *cors) isOriginValid(c *fw.Context, origin string) bool {
-	valid := cors.validateOrigin(origin)
-	if !valid && cors.allowOriginWithContextFunc != nil {
-		valid = cors.allowOriginWithContextFunc(c, origin)
+// isOriginValid returns the rule which allows the origin, empty when the origin is not allowed
+func (cors *cors) isOriginValid(ctx context.Context, c *fw.Context, origin string) string {
+	rule := cors.validateOrigin(ctx, origin)
+	if rule == "" && origin != nullOrigin && cors.allowOriginWithContextFunc != nil && cors.allowOriginWithContextFunc(c, origin) {
+		rule = "AllowOriginWithContextFunc"
 	}
-	return valid
+	return rule
 }
 
-func (cors *cors) validateOrigin(origin string) bool {
+func (cors *cors) validateOrigin(ctx context.Context, origin string) string {
+	if origin == nullOrigin {
+		// sandboxed iframes, file pages and cross origin redirects, anyone can make such a request
+		// so only allow it explicitly
+		if cors.allowNullOrigin {
+			return "AllowNullOrigin"
+		}
+		if cors.allowAllOrigins {
+			return "*"
+		}
+		return ""
+	}
 	if cors.allowAllOrigins {
-		return true
+		return "*"
 	}
-	for _, value := range cors.allowOrigins {
-		if value == origin {
-			return true
+	if rule := cors.matchOrigin(ctx, origin); rule != "" {
+		return rule
+	}
+	if cors.allowOriginFunc != nil && cors.allowOriginFunc(origin) {
+		return "AllowOriginFunc"
+	}
+	return ""
+}
+
+// matchOrigin checks exact origins, wildcard patterns and regular expressions, it returns the matched rule
+func (cors *cors) matchOrigin(ctx context.Context, origin string) string {
+	// browsers send normalized origins, look them up before normalizing
+	if rule, ok := cors.exactOrigins[origin]; ok {
+		return rule
+	}
+	scheme, host, port, ok := parseOrigin(origin)
+	if !ok {
+		// opaque origins like null can only be listed as is
+		return cors.exactOrigins[strings.ToLower(origin)]
+	}
+	normalized := origin
+	if !isNormalized(origin, scheme, host, port) {
+		normalized = scheme + "://" + host
+		if port != "" {
+			normalized += ":" + port
+		}
+		if rule, ok := cors.exactOrigins[normalized]; ok {
+			return rule
 		}
 	}
-	if len(cors.wildcardOrigins) > 0 && cors.validateWildcardOrigin(origin) {
-		return true
+	for _, p := range cors.originPatterns {
+		if p.match(scheme, host, port) {
+			return p.rule
+		}
 	}
-	if cors.allowOriginFunc != nil {
-		return cors.allowOriginFunc(origin)
+	for i, re := range cors.originRegexps {
+		if re.MatchString(normalized) {
+			return cors.originRegexRules[i]
+		}
 	}
-	return false
+	if cors.originSource != nil && cors.originSource.allowed(ctx, normalized) {
+		return "AllowOriginSource"
+	}
+	return ""
 }
 
-func (cors *cors) handlePreflight(c *fw.Context) {
+// validatePreflight checks the requested method, headers and private network access,
+// it returns why the preflight is rejected or an empty string
+func (cors *cors) validatePreflight(fctx *fasthttp.RequestCtx) string {
+	header := &fctx.Request.Header
+	method := strings.ToUpper(strings.TrimSpace(conv.UnsafeBytesToStr(header.PeekBytes(headerRequestMethod))))
+	if !safelistedMethods[method] && !cors.allowMethods[method] {
+		return "method " + method + " not allowed"
+	}
+	if !cors.reflectRequestHeaders && !cors.allowAnyHeader {
+		rest := conv.UnsafeBytesToStr(header.PeekBytes(headerRequestHeaders))
+		for rest != "" {
+			var name string
+			name, rest, _ = strings.Cut(rest, ",")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != "" && !safelistedHeaders[name] && !cors.allowHeaders[name] {
+				return "header " + name + " not allowed"
+			}
+		}
+	}
+	if string(header.PeekBytes(headerRequestPrivateNetwork)) == "true" && !cors.allowPrivateNetwork {
+		return "private network access not allowed"
+	}
+	return ""
+}
 
-	for key, value := range cors.preflightHeaders {
-		c.GetFastContext().Response.Header.Set(key, strings.Join(value, ";"))
+func (cors *cors) handlePreflight(fctx *fasthttp.RequestCtx) {
+	for _, h := range cors.preflightHeaders {
+		fctx.Response.Header.SetBytesKV(h.key, h.value)
+	}
+	if requested := fctx.Request.Header.PeekBytes(headerRequestHeaders); len(requested) > 0 &&
+		(cors.reflectRequestHeaders || cors.allowAnyHeader) {
+		fctx.Response.Header.SetBytesKV(headerAllowHeaders, requested)
+	}
+	// only answer private network preflights, see https://wicg.github.io/private-network-access/
+	if cors.allowPrivateNetwork && string(fctx.Request.Header.PeekBytes(headerRequestPrivateNetwork)) == "true" {
+		fctx.Response.Header.SetBytesKV(headerAllowPrivateNetwork, valueTrue)
 	}
 }
 
-func (cors *cors) handleNormal(c *fw.Context) {
-	for key, value := range cors.normalHeaders {
-		c.GetFastContext().Response.Header.Set(key, strings.Join(value, ";"))
+func (cors *cors) handleNormal(fctx *fasthttp.RequestCtx) {
+	for _, h := range cors.normalHeaders {
+		fctx.Response.Header.SetBytesKV(h.key, h.value)
 	}
 }
 
@@ -297,6 +685,14 @@ type Config struct {
 	// Default value is []
 	AllowOrigins []string
 
+	// AllowOriginRegex is a list of regular expressions matched against the whole origin,
+	// lower cased and without default port, e.g. https://pr-[0-9]+\.preview\.example\.com
+	AllowOriginRegex []string
+
+	// AllowOriginSource provides exact origins which change at runtime, e.g. per tenant domains.
+	// they are cached, wrap the source with NewCachedOriginSource to choose the ttl or refresh it periodically
+	AllowOriginSource OriginSource
+
 	// AllowOriginFunc is a custom function to validate the origin. It takes the origin
 	// as an argument and returns true if allowed or false otherwise. If this option is
 	// set, the content of AllowOrigins is ignored.
@@ -316,9 +712,13 @@ type Config struct {
 	AllowPrivateNetwork bool
 
 	// AllowHeaders is list of non simple headers the client is allowed to use with
-	// cross-domain requests.
+	// cross-domain requests. "*" allows any header.
 	AllowHeaders []string
 
+	// ReflectRequestHeaders answers preflight requests with the requested headers
+	// instead of AllowHeaders, every requested header is allowed
+	ReflectRequestHeaders bool
+
 	// AllowCredentials indicates whether the request can include user credentials like
 	// cookies, HTTP authentication or client side SSL certificates.
 	AllowCredentials bool
@@ -331,7 +731,8 @@ type Config struct {
 	// can be cached
 	MaxAge time.Duration
 
-	// Allows to add origins like http://some-domain/*, https://api.* or http://some.*.subdomain.com
+	// Allows to add origins like https://*.example.com, https://api.*, http://some.*.subdomain.com,
+	// https://pr-*.example.com or http://localhost:*, see originPattern
 	AllowWildcard bool
 
 	// Allows usage of popular browser extensions schemas
@@ -348,6 +749,17 @@ type Config struct {
 
 	// Allows to pass custom OPTIONS response status code for old browsers / clients
 	OptionsResponseStatusCode int
+
+	// TrustedProxies are ips or cidrs of proxies whose Forwarded, X-Forwarded-Proto, X-Forwarded-Host
+	// and X-Forwarded-Port headers are used to recognize same origin requests
+	TrustedProxies []string
+
+	// AllowNullOrigin allows the opaque null origin of sandboxed iframes, file pages and redirects.
+	// it is not matched by AllowOrigins or the origin funcs
+	AllowNullOrigin bool
+
+	// Debug adds the X-CORS-Debug header with the reason of the decision and logs rejected requests
+	Debug bool
 }
 
 // AddAllowMethods is allowed to add custom methods
@@ -396,59 +808,75 @@ func (c Config) validateAllowedSchemas(origin string) bool {
 func (c Config) Validate() error {
 	hasOriginFn := c.AllowOriginFunc != nil
 	hasOriginFn = hasOriginFn || c.AllowOriginWithContextFunc != nil
+	hasOriginFn = hasOriginFn || c.AllowOriginSource != nil
 
-	if c.AllowAllOrigins && (hasOriginFn || len(c.AllowOrigins) > 0) {
+	if c.AllowAllOrigins && (hasOriginFn || len(c.AllowOrigins) > 0 || len(c.AllowOriginRegex) > 0) {
 		originFields := strings.Join([]string{
 			"AllowOriginFunc",
 			"AllowOriginFuncWithContext",
 			"AllowOrigins",
+			"AllowOriginRegex",
+			"AllowOriginSource",
 		}, " or ")
 		return fmt.Errorf(
 			"conflict settings: all origins enabled. %s is not needed",
 			originFields,
 		)
 	}
-	if !c.AllowAllOrigins && !hasOriginFn && len(c.AllowOrigins) == 0 {
+	if !c.AllowAllOrigins && !hasOriginFn && len(c.AllowOrigins) == 0 && len(c.AllowOriginRegex) == 0 {
 		return errors.New("conflict settings: all origins disabled")
 	}
 	for _, origin := range c.AllowOrigins {
 		if !strings.Contains(origin, "*") && !c.validateAllowedSchemas(origin) {
 			return errors.New("bad origin: origins must contain '*' or include " + strings.Join(c.getAllowedSchemas(), ","))
 		}
+		if c.AllowWildcard && origin != "*" && strings.Contains(origin, "*") {
+			if _, err := parseOriginPattern(origin); err != nil {
+				return err
+			}
+		}
+	}
+	for _, expr := range c.AllowOriginRegex {
+		if _, err := compileOriginRegex(expr); err != nil {
+			return errors.New("bad origin regex: " + err.Error())
+		}
+	}
+	if _, err := parseTrustedProxies(c.TrustedProxies); err != nil {
+		return err
 	}
 	return nil
 }
 
-func (c Config) parseWildcardRules() [][]string {
-	var wRules [][]string
-
-	if !c.AllowWildcard {
-		return wRules
-	}
-
-	for _, o := range c.AllowOrigins {
-		if !strings.Contains(o, "*") {
+// parseOrigins splits AllowOrigins into exact origins and wildcard patterns and compiles AllowOriginRegex,
+// the config must be valid
+func (c Config) parseOrigins() (map[string]string, []*originPattern, []*regexp.Regexp) {
+	exact := make(map[string]string, len(c.AllowOrigins))
+	var patterns []*originPattern
+	for _, origin := range c.AllowOrigins {
+		origin = strings.TrimSpace(origin)
+		if origin == "*" {
 			continue
 		}
-
-		if c := strings.Count(o, "*"); c > 1 {
-			panic(errors.New("only one * is allowed").Error())
-		}
-
-		i := strings.Index(o, "*")
-		if i == 0 {
-			wRules = append(wRules, []string{"*", o[1:]})
+		if strings.Contains(origin, "*") {
+			// wildcards are ignored unless enabled
+			if c.AllowWildcard {
+				p, _ := parseOriginPattern(origin)
+				patterns = append(patterns, p)
+			}
 			continue
 		}
-		if i == (len(o) - 1) {
-			wRules = append(wRules, []string{o[:i], "*"})
-			continue
+		if normalized, ok := normalizeOrigin(origin); ok {
+			exact[normalized] = "origin " + normalized
+		} else {
+			exact[strings.ToLower(origin)] = "origin " + origin
 		}
-
-		wRules = append(wRules, []string{o[:i], o[i+1:]})
 	}
-
-	return wRules
+	regexps := make([]*regexp.Regexp, 0, len(c.AllowOriginRegex))
+	for _, expr := range c.AllowOriginRegex {
+		re, _ := compileOriginRegex(expr)
+		regexps = append(regexps, re)
+	}
+	return exact, patterns, regexps
 }
 
 // DefaultConfig returns a generic default configuration mapped to localhost.
@@ -0,0 +1,198 @@
+package cors
+
+import (
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"testing"
+)
+
+func benchConfig() Config {
+	config := DefaultConfig()
+	config.AllowAllOrigins = false
+	config.AllowOrigins = []string{"https://app.example.com", "https://*.preview.example.com"}
+	config.AllowWildcard = true
+	config.AllowHeaders = []string{"Authorization", "Content-Type"}
+	config.AllowCredentials = true
+	return config
+}
+
+func corsRequest(method string, origin string, preflight bool) *fasthttp.RequestCtx {
+	fctx := &fasthttp.RequestCtx{}
+	fctx.Request.Header.SetMethod(method)
+	fctx.Request.SetRequestURI("/orders")
+	fctx.Request.Header.SetHost("api.example.com")
+	if origin != "" {
+		fctx.Request.Header.Set("Origin", origin)
+	}
+	if preflight {
+		fctx.Request.Header.Set("Access-Control-Request-Method", "PUT")
+		fctx.Request.Header.Set("Access-Control-Request-Headers", "authorization,content-type")
+	}
+	return fctx
+}
+
+var corsCases = []struct {
+	name      string
+	method    string
+	origin    string
+	preflight bool
+	want      bool
+}{
+	{"NoOrigin", "GET", "", false, true},
+	{"SameOrigin", "GET", "http://api.example.com", false, true},
+	{"Exact", "GET", "https://app.example.com", false, true},
+	{"Wildcard", "GET", "https://pr-1.preview.example.com", false, true},
+	{"Preflight", "OPTIONS", "https://app.example.com", true, false},
+}
+
+func TestCorsApply(t *testing.T) {
+	cors := newCors(benchConfig())
+	for _, tt := range corsCases {
+		fctx := corsRequest(tt.method, tt.origin, tt.preflight)
+		if got := cors.apply(fctx, nil); got != tt.want {
+			t.Errorf("%s: apply = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if cors.apply(corsRequest("GET", "https://evil.example.org", false), nil) {
+		t.Error("foreign origin allowed")
+	}
+}
+
+func TestCorsApplyDoesNotAllocate(t *testing.T) {
+	c := NewCorsMiddleware(benchConfig())
+	c.current.Store(c.build(c.config))
+	p := c.route(policyKey("Orders", "Create"))
+	for _, tt := range corsCases {
+		fctx := corsRequest(tt.method, tt.origin, tt.preflight)
+		if n := testing.AllocsPerRun(100, func() {
+			c.load(p).apply(fctx, nil)
+		}); n != 0 {
+			t.Errorf("%s: %v allocs per request", tt.name, n)
+		}
+	}
+}
+
+func benchmarkCors(b *testing.B, method string, origin string, preflight bool) {
+	c := NewCorsMiddleware(benchConfig())
+	c.current.Store(c.build(c.config))
+	// resolved once in Execute
+	p := c.route(policyKey("Orders", "Create"))
+	fctx := corsRequest(method, origin, preflight)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.load(p).apply(fctx, nil)
+	}
+}
+
+func BenchmarkCorsNoOrigin(b *testing.B) {
+	benchmarkCors(b, "GET", "", false)
+}
+
+func BenchmarkCorsExactOrigin(b *testing.B) {
+	benchmarkCors(b, "GET", "https://app.example.com", false)
+}
+
+func BenchmarkCorsWildcardOrigin(b *testing.B) {
+	benchmarkCors(b, "GET", "https://pr-1.preview.example.com", false)
+}
+
+func BenchmarkCorsPreflight(b *testing.B) {
+	benchmarkCors(b, "OPTIONS", "https://app.example.com", true)
+}
+
+func preflight(method string, headers string) *fasthttp.RequestCtx {
+	fctx := corsRequest("OPTIONS", "https://app.example.com", false)
+	fctx.Request.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		fctx.Request.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	return fctx
+}
+
+func TestPreflightValidation(t *testing.T) {
+	config := benchConfig()
+	config.AllowMethods = []string{"GET", "PUT"}
+	config.Debug = true
+	strict := newCors(config)
+	config.ReflectRequestHeaders = true
+	reflect := newCors(config)
+	config.ReflectRequestHeaders = false
+	config.AllowHeaders = []string{"*"}
+	anyHeader := newCors(config)
+	config.AllowHeaders = benchConfig().AllowHeaders
+	config.AllowPrivateNetwork = true
+	private := newCors(config)
+
+	for _, tt := range []struct {
+		name    string
+		cors    *cors
+		method  string
+		headers string
+		private bool
+		reason  string // empty when the preflight is answered
+	}{
+		{"allowed method", strict, "PUT", "", false, ""},
+		{"method case", strict, " put ", "", false, ""},
+		{"safelisted method", strict, "POST", "", false, ""},
+		{"method not allowed", strict, "DELETE", "", false, "method DELETE not allowed"},
+		{"allowed headers", strict, "PUT", "Authorization, content-type", false, ""},
+		{"safelisted header", strict, "PUT", "accept,Accept-Language", false, ""},
+		{"empty header names", strict, "PUT", ",authorization,,", false, ""},
+		{"header not allowed", strict, "PUT", "authorization,x-api-key", false, "header x-api-key not allowed"},
+		{"header prefix", strict, "PUT", "authorization-x", false, "header authorization-x not allowed"},
+		{"reflected headers", reflect, "PUT", "x-api-key", false, ""},
+		{"reflected method", reflect, "DELETE", "x-api-key", false, "method DELETE not allowed"},
+		{"any header", anyHeader, "PUT", "x-api-key", false, ""},
+		{"private network", strict, "PUT", "", true, "private network access not allowed"},
+		{"private network allowed", private, "PUT", "", true, ""},
+	} {
+		fctx := preflight(tt.method, tt.headers)
+		if tt.private {
+			fctx.Request.Header.Set("Access-Control-Request-Private-Network", "true")
+		}
+		if tt.cors.apply(fctx, nil) {
+			t.Errorf("%s: preflight passed to the handler", tt.name)
+		}
+		status := fctx.Response.StatusCode()
+		debug := string(fctx.Response.Header.Peek(debugHeader))
+		if tt.reason != "" {
+			if status != http.StatusForbidden || debug != tt.reason {
+				t.Errorf("%s: %d %q, want 403 %q", tt.name, status, debug, tt.reason)
+			}
+			if v := fctx.Response.Header.Peek("Access-Control-Allow-Origin"); len(v) > 0 {
+				t.Errorf("%s: rejected preflight allows the origin", tt.name)
+			}
+			continue
+		}
+		if status != http.StatusNoContent || string(fctx.Response.Header.Peek("Access-Control-Allow-Origin")) != "https://app.example.com" {
+			t.Errorf("%s: %d %q, want 204 and the origin allowed", tt.name, status, debug)
+		}
+		if v := string(fctx.Response.Header.Peek("Access-Control-Allow-Private-Network")); (v == "true") != tt.private {
+			t.Errorf("%s: Access-Control-Allow-Private-Network = %q", tt.name, v)
+		}
+	}
+}
+
+func TestPreflightReflectsHeaders(t *testing.T) {
+	config := benchConfig()
+	config.ReflectRequestHeaders = true
+	fctx := preflight("PUT", "x-api-key,x-trace")
+	newCors(config).apply(fctx, nil)
+	if v := string(fctx.Response.Header.Peek("Access-Control-Allow-Headers")); v != "x-api-key,x-trace" {
+		t.Fatalf("Access-Control-Allow-Headers = %q", v)
+	}
+	// without reflection the configured list is answered, not the requested one
+	fctx = preflight("PUT", "authorization")
+	newCors(benchConfig()).apply(fctx, nil)
+	if v := string(fctx.Response.Header.Peek("Access-Control-Allow-Headers")); v != "Authorization,Content-Type" {
+		t.Fatalf("Access-Control-Allow-Headers = %q", v)
+	}
+}
+
+func TestOptionsWithoutRequestMethodIsNoPreflight(t *testing.T) {
+	fctx := corsRequest("OPTIONS", "https://app.example.com", false)
+	if !newCors(benchConfig()).apply(fctx, nil) {
+		t.Fatal("plain OPTIONS request not passed to the handler")
+	}
+}
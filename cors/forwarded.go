@@ -0,0 +1,136 @@
+package cors
+
+import (
+	"errors"
+	"github.com/linxlib/conv"
+	"github.com/valyala/fasthttp"
+	"net"
+	"strings"
+)
+
+const nullOrigin = "null"
+
+// parseTrustedProxies parses ips and cidrs
+func parseTrustedProxies(values []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(values))
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, errors.New("bad trusted proxy: " + v)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, errors.New("bad trusted proxy: " + err.Error())
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func (cors *cors) fromTrustedProxy(fctx *fasthttp.RequestCtx) bool {
+	if len(cors.trustedProxies) == 0 {
+		return false
+	}
+	ip := fctx.RemoteIP()
+	for _, n := range cors.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstValue returns the first element of a comma separated header, i.e. the one set by the outermost proxy
+func firstValue(v []byte) string {
+	s, _, _ := strings.Cut(conv.UnsafeBytesToStr(v), ",")
+	return strings.TrimSpace(s)
+}
+
+// forwarded reads proto and host of the first element of a RFC 7239 Forwarded header
+func forwarded(v []byte) (proto string, host string) {
+	for _, pair := range strings.Split(firstValue(v), ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+		value = strings.Trim(value, `"`)
+		switch strings.ToLower(key) {
+		case "proto":
+			proto = strings.ToLower(value)
+		case "host":
+			host = value
+		}
+	}
+	return
+}
+
+// requestOrigin returns the origin the client used to reach us. the scheme is empty
+// when it is unknown, i.e. plain http which may be terminated TLS of an untrusted proxy.
+func (cors *cors) requestOrigin(fctx *fasthttp.RequestCtx) (scheme string, host string) {
+	// only compared while the request is served, no need to copy
+	host = conv.UnsafeBytesToStr(fctx.Host())
+	if fctx.IsTLS() {
+		scheme = "https"
+	}
+	if !cors.fromTrustedProxy(fctx) {
+		return
+	}
+	header := &fctx.Request.Header
+	if v := header.Peek("Forwarded"); len(v) > 0 {
+		proto, forwardedHost := forwarded(v)
+		if proto != "" {
+			scheme = proto
+		}
+		if forwardedHost != "" {
+			host = forwardedHost
+		}
+		return
+	}
+	if v := header.Peek("X-Forwarded-Proto"); len(v) > 0 {
+		scheme = strings.ToLower(firstValue(v))
+	}
+	if v := header.Peek("X-Forwarded-Host"); len(v) > 0 {
+		host = firstValue(v)
+	}
+	if v := header.Peek("X-Forwarded-Port"); len(v) > 0 && !hasPort(host) {
+		host += ":" + firstValue(v)
+	}
+	return
+}
+
+func hasPort(host string) bool {
+	if strings.HasPrefix(host, "[") {
+		return strings.Contains(host, "]:")
+	}
+	return strings.Contains(host, ":")
+}
+
+// sameOrigin reports whether origin is the origin of the request itself, e.g. a fetch from our own page
+func (cors *cors) sameOrigin(fctx *fasthttp.RequestCtx, origin string) bool {
+	scheme, host, port, ok := parseOrigin(origin)
+	if !ok {
+		return false
+	}
+	requestScheme, requestHost := cors.requestOrigin(fctx)
+	schemes := []string{requestScheme}
+	if requestScheme == "" {
+		schemes = []string{"http", "https"}
+	}
+	for _, s := range schemes {
+		if s != scheme {
+			continue
+		}
+		h, p, ok := parseHostPort(s, requestHost)
+		if ok && h == host && p == port {
+			return true
+		}
+	}
+	return false
+}
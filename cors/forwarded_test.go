@@ -0,0 +1,122 @@
+package cors
+
+import (
+	"github.com/valyala/fasthttp"
+	"net"
+	"testing"
+)
+
+// proxiedRequest is a request for api.example.com arriving from remote with the given headers
+func proxiedRequest(remote string, headers ...string) *fasthttp.RequestCtx {
+	req := &fasthttp.Request{}
+	req.Header.SetMethod("GET")
+	req.SetRequestURI("/orders")
+	req.Header.SetHost("api.example.com")
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Add(headers[i], headers[i+1])
+	}
+	fctx := &fasthttp.RequestCtx{}
+	fctx.Init(req, &net.TCPAddr{IP: net.ParseIP(remote), Port: 40000}, nil)
+	return fctx
+}
+
+func TestRequestOrigin(t *testing.T) {
+	config := benchConfig()
+	config.TrustedProxies = []string{"10.0.0.0/8", "fd00::1"}
+	cors := newCors(config)
+	for _, tt := range []struct {
+		name    string
+		remote  string
+		headers []string
+		scheme  string
+		host    string
+	}{
+		{"direct", "203.0.113.9", nil, "", "api.example.com"},
+		{"untrusted proxy", "203.0.113.9", []string{"X-Forwarded-Proto", "https", "X-Forwarded-Host", "evil.example.com"}, "", "api.example.com"},
+		{"untrusted forwarded", "203.0.113.9", []string{"Forwarded", "proto=https;host=evil.example.com"}, "", "api.example.com"},
+		{"trusted x-forwarded", "10.1.2.3", []string{"X-Forwarded-Proto", "HTTPS", "X-Forwarded-Host", "app.example.com"}, "https", "app.example.com"},
+		{"trusted ipv6", "fd00::1", []string{"X-Forwarded-Proto", "https"}, "https", "api.example.com"},
+		{"first value", "10.1.2.3", []string{"X-Forwarded-Proto", "https, http", "X-Forwarded-Host", "app.example.com, internal.lan"}, "https", "app.example.com"},
+		{"port", "10.1.2.3", []string{"X-Forwarded-Host", "app.example.com", "X-Forwarded-Port", "8443"}, "", "app.example.com:8443"},
+		{"port in host", "10.1.2.3", []string{"X-Forwarded-Host", "app.example.com:9000", "X-Forwarded-Port", "8443"}, "", "app.example.com:9000"},
+		{"ipv6 host", "10.1.2.3", []string{"X-Forwarded-Host", "[2001:db8::1]", "X-Forwarded-Port", "8443"}, "", "[2001:db8::1]:8443"},
+		{"forwarded", "10.1.2.3", []string{"Forwarded", `for=192.0.2.1;Proto=HTTPS;Host="app.example.com"`}, "https", "app.example.com"},
+		{"forwarded first element", "10.1.2.3", []string{"Forwarded", "proto=https;host=app.example.com, proto=http;host=internal.lan"}, "https", "app.example.com"},
+		{"forwarded wins", "10.1.2.3", []string{"Forwarded", "proto=https", "X-Forwarded-Host", "evil.example.com"}, "https", "api.example.com"},
+	} {
+		scheme, host := cors.requestOrigin(proxiedRequest(tt.remote, tt.headers...))
+		if scheme != tt.scheme || host != tt.host {
+			t.Errorf("%s: %q %q, want %q %q", tt.name, scheme, host, tt.scheme, tt.host)
+		}
+	}
+
+	// without trusted proxies the headers are never read
+	untrusting := newCors(benchConfig())
+	fctx := proxiedRequest("10.1.2.3", "X-Forwarded-Proto", "https", "X-Forwarded-Host", "app.example.com")
+	if scheme, host := untrusting.requestOrigin(fctx); scheme != "" || host != "api.example.com" {
+		t.Errorf("no trusted proxies: %q %q", scheme, host)
+	}
+}
+
+func TestSameOrigin(t *testing.T) {
+	config := benchConfig()
+	config.TrustedProxies = []string{"10.0.0.0/8"}
+	cors := newCors(config)
+	behindProxy := []string{"X-Forwarded-Proto", "https", "X-Forwarded-Host", "app.example.com"}
+	for _, tt := range []struct {
+		name    string
+		remote  string
+		headers []string
+		origin  string
+		want    bool
+	}{
+		// the scheme is unknown without a trusted proxy, both match the host
+		{"direct http", "203.0.113.9", nil, "http://api.example.com", true},
+		{"direct https", "203.0.113.9", nil, "https://api.example.com", true},
+		{"default port", "203.0.113.9", nil, "https://api.example.com:443", true},
+		{"other port", "203.0.113.9", nil, "https://api.example.com:8443", false},
+		{"host case", "203.0.113.9", nil, "https://API.example.com", true},
+		{"other host", "203.0.113.9", nil, "https://app.example.com", false},
+		{"null", "203.0.113.9", nil, "null", false},
+		{"malformed", "203.0.113.9", nil, "api.example.com", false},
+		{"spoofed by a client", "203.0.113.9", behindProxy, "https://app.example.com", false},
+		{"behind proxy", "10.1.2.3", behindProxy, "https://app.example.com", true},
+		{"behind proxy scheme", "10.1.2.3", behindProxy, "http://app.example.com", false},
+		{"behind proxy host header", "10.1.2.3", behindProxy, "https://api.example.com", false},
+		{"behind proxy port", "10.1.2.3", []string{"X-Forwarded-Proto", "https", "X-Forwarded-Host", "app.example.com", "X-Forwarded-Port", "443"}, "https://app.example.com", true},
+		{"forwarded", "10.1.2.3", []string{"Forwarded", "proto=https;host=app.example.com:8443"}, "https://app.example.com:8443", true},
+	} {
+		fctx := proxiedRequest(tt.remote, tt.headers...)
+		if got := cors.sameOrigin(fctx, tt.origin); got != tt.want {
+			t.Errorf("%s: sameOrigin(%q) = %v, want %v", tt.name, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestSameOriginIsNotACorsRequest(t *testing.T) {
+	config := benchConfig()
+	config.TrustedProxies = []string{"10.0.0.0/8"}
+	// app.example.com is no allowed origin, but it is the origin the proxy serves us under
+	config.AllowOrigins = []string{"https://web.example.com"}
+	cors := newCors(config)
+	fctx := proxiedRequest("10.1.2.3", "Origin", "https://app.example.com", "X-Forwarded-Proto", "https", "X-Forwarded-Host", "app.example.com")
+	if !cors.apply(fctx, nil) || len(fctx.Response.Header.Peek("Access-Control-Allow-Origin")) > 0 {
+		t.Fatal("same origin request behind the proxy treated as cross origin")
+	}
+	fctx = proxiedRequest("203.0.113.9", "Origin", "https://app.example.com", "X-Forwarded-Proto", "https", "X-Forwarded-Host", "app.example.com")
+	if cors.apply(fctx, nil) {
+		t.Fatal("client spoofing the proxy headers passed as same origin")
+	}
+}
+
+func TestNullOrigin(t *testing.T) {
+	config := benchConfig()
+	if newCors(config).apply(corsRequest("GET", "null", false), nil) {
+		t.Error("null origin allowed by default")
+	}
+	config.AllowNullOrigin = true
+	fctx := corsRequest("GET", "null", false)
+	if !newCors(config).apply(fctx, nil) || string(fctx.Response.Header.Peek("Access-Control-Allow-Origin")) != "null" {
+		t.Error("null origin rejected with AllowNullOrigin")
+	}
+}
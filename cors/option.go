@@ -0,0 +1,145 @@
+package cors
+
+import (
+	"errors"
+	"gopkg.in/yaml.v3"
+	"os"
+	"time"
+)
+
+// CorsOption is the yaml form of Config, fields have the same meaning as in Config.
+// functions can not be serialized, AllowOriginFunc and AllowOriginWithContextFunc are only available from Go.
+type CorsOption struct {
+	AllowAllOrigins           bool     `yaml:"allow_all_origins" default:"false"`
+	AllowOrigins              []string `yaml:"allow_origins"`
+	AllowOriginRegex          []string `yaml:"allow_origin_regex"`
+	AllowOriginSource         string   `yaml:"allow_origin_source" default:""` // name given to RegisterOriginSource
+	OriginSourceTTL           string   `yaml:"origin_source_ttl" default:"1m"`
+	OriginSourceRefresh       string   `yaml:"origin_source_refresh" default:""` // refreshed on demand when empty
+	AllowMethods              []string `yaml:"allow_methods"`                    // GET, POST, PUT, PATCH, DELETE, HEAD and OPTIONS when empty
+	AllowPrivateNetwork       bool     `yaml:"allow_private_network" default:"false"`
+	AllowHeaders              []string `yaml:"allow_headers"` // Origin, Content-Length and Content-Type when empty
+	ReflectRequestHeaders     bool     `yaml:"reflect_request_headers" default:"false"`
+	AllowCredentials          bool     `yaml:"allow_credentials" default:"false"`
+	ExposeHeaders             []string `yaml:"expose_headers"`
+	MaxAge                    string   `yaml:"max_age" default:"12h"`
+	AllowWildcard             bool     `yaml:"allow_wildcard" default:"false"`
+	AllowBrowserExtensions    bool     `yaml:"allow_browser_extensions" default:"false"`
+	CustomSchemas             []string `yaml:"custom_schemas"`
+	AllowWebSockets           bool     `yaml:"allow_web_sockets" default:"false"`
+	AllowFiles                bool     `yaml:"allow_files" default:"false"`
+	OptionsResponseStatusCode int      `yaml:"options_response_status_code" default:"204"`
+	TrustedProxies            []string `yaml:"trusted_proxies"`
+	AllowNullOrigin           bool     `yaml:"allow_null_origin" default:"false"`
+	Debug                     bool     `yaml:"debug" default:"false"`
+
+	// File is watched and the policy is swapped when it changes. it may be the app config
+	// with a cors section or a file with the fields above at top level, its values override this section.
+	File          string `yaml:"file" default:""`
+	WatchInterval string `yaml:"watch_interval" default:"5s"`
+}
+
+// Config converts the option to a validated Config
+func (o *CorsOption) Config() (Config, error) {
+	config := Config{
+		AllowAllOrigins:           o.AllowAllOrigins,
+		AllowOrigins:              o.AllowOrigins,
+		AllowOriginRegex:          o.AllowOriginRegex,
+		AllowMethods:              o.AllowMethods,
+		AllowPrivateNetwork:       o.AllowPrivateNetwork,
+		AllowHeaders:              o.AllowHeaders,
+		ReflectRequestHeaders:     o.ReflectRequestHeaders,
+		AllowCredentials:          o.AllowCredentials,
+		ExposeHeaders:             o.ExposeHeaders,
+		AllowWildcard:             o.AllowWildcard,
+		AllowBrowserExtensions:    o.AllowBrowserExtensions,
+		CustomSchemas:             o.CustomSchemas,
+		AllowWebSockets:           o.AllowWebSockets,
+		AllowFiles:                o.AllowFiles,
+		OptionsResponseStatusCode: o.OptionsResponseStatusCode,
+		TrustedProxies:            o.TrustedProxies,
+		AllowNullOrigin:           o.AllowNullOrigin,
+		Debug:                     o.Debug,
+	}
+	defaults := DefaultConfig()
+	if len(config.AllowMethods) == 0 {
+		config.AllowMethods = defaults.AllowMethods
+	}
+	if len(config.AllowHeaders) == 0 {
+		config.AllowHeaders = defaults.AllowHeaders
+	}
+	if o.MaxAge != "" {
+		d, err := time.ParseDuration(o.MaxAge)
+		if err != nil {
+			return config, errors.New("cors: invalid max_age: " + err.Error())
+		}
+		config.MaxAge = d
+	}
+	if o.AllowOriginSource != "" {
+		source, err := o.originSource()
+		if err != nil {
+			return config, err
+		}
+		config.AllowOriginSource = source
+	}
+	if err := config.Validate(); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+func (o *CorsOption) originSource() (*CachedOriginSource, error) {
+	var ttl, refresh time.Duration
+	var err error
+	if o.OriginSourceTTL != "" {
+		if ttl, err = time.ParseDuration(o.OriginSourceTTL); err != nil {
+			return nil, errors.New("cors: invalid origin_source_ttl: " + err.Error())
+		}
+	}
+	if o.OriginSourceRefresh != "" {
+		if refresh, err = time.ParseDuration(o.OriginSourceRefresh); err != nil {
+			return nil, errors.New("cors: invalid origin_source_refresh: " + err.Error())
+		}
+	}
+	return cachedOriginSource(o.AllowOriginSource, ttl, refresh)
+}
+
+// overlay reads file and returns a copy of o with the values of the file
+func (o *CorsOption) overlay(file string) (*CorsOption, error) {
+	bs, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	// the app config keeps the options under cors. a *yaml.Node field is decoded as an empty node
+	// instead of the section, the kind tells whether the key exists.
+	var section struct {
+		Cors yaml.Node `yaml:"cors"`
+	}
+	if err = yaml.Unmarshal(bs, &section); err != nil {
+		return nil, err
+	}
+	merged := *o
+	if section.Cors.Kind != 0 {
+		err = section.Cors.Decode(&merged)
+	} else {
+		err = yaml.Unmarshal(bs, &merged)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &merged, nil
+}
+
+// fileVersion identifies the content of a file without reading it
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(file string) (fileVersion, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return fileVersion{}, err
+	}
+	return fileVersion{modTime: info.ModTime(), size: info.Size()}, nil
+}
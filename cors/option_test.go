@@ -0,0 +1,183 @@
+package cors
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, name string, content string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// yamlOption is the cors section with the defaults LoadConfig would fill in
+func yamlOption(file string) *CorsOption {
+	return &CorsOption{
+		AllowOrigins:              []string{"https://app.example.com"},
+		OriginSourceTTL:           "1m",
+		MaxAge:                    "12h",
+		OptionsResponseStatusCode: 204,
+		File:                      file,
+		WatchInterval:             "5s",
+	}
+}
+
+func allowsOrigin(c *cors, origin string) bool {
+	return c.apply(corsRequest("GET", origin, false), nil)
+}
+
+func TestOverlay(t *testing.T) {
+	dir := t.TempDir()
+	app := filepath.Join(dir, "app.yaml")
+	writeFile(t, app, "server:\n  port: 8080\ncors:\n  allow_origins: [\"https://admin.example.com\"]\n  allow_credentials: true\n")
+	top := filepath.Join(dir, "cors.yaml")
+	writeFile(t, top, "allow_origins:\n  - https://top.example.com\nmax_age: 1h\n")
+
+	base := yamlOption(app)
+	merged, err := base.overlay(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged.AllowOrigins) != 1 || merged.AllowOrigins[0] != "https://admin.example.com" || !merged.AllowCredentials {
+		t.Fatalf("cors section not applied: %+v", merged)
+	}
+	// values the file does not set are kept, the base option is not changed
+	if merged.MaxAge != "12h" || merged.File != app {
+		t.Errorf("unset values lost: max_age %q, file %q", merged.MaxAge, merged.File)
+	}
+	if base.AllowOrigins[0] != "https://app.example.com" || base.AllowCredentials {
+		t.Errorf("overlay changed the base option: %+v", base)
+	}
+
+	merged, err = base.overlay(top)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.AllowOrigins[0] != "https://top.example.com" || merged.MaxAge != "1h" {
+		t.Fatalf("top level file not applied: %+v", merged)
+	}
+
+	broken := filepath.Join(dir, "broken.yaml")
+	writeFile(t, broken, "allow_origins: [\n")
+	if _, err = base.overlay(broken); err == nil {
+		t.Error("invalid yaml accepted")
+	}
+	if _, err = base.overlay(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("missing file accepted")
+	}
+}
+
+func TestOptionConfig(t *testing.T) {
+	config, err := yamlOption("").Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := DefaultConfig()
+	if len(config.AllowMethods) != len(defaults.AllowMethods) || len(config.AllowHeaders) != len(defaults.AllowHeaders) {
+		t.Errorf("empty methods or headers not defaulted: %v %v", config.AllowMethods, config.AllowHeaders)
+	}
+	if config.MaxAge != 12*time.Hour {
+		t.Errorf("max_age = %s", config.MaxAge)
+	}
+	for name, option := range map[string]*CorsOption{
+		"max_age":        {AllowOrigins: []string{"https://app.example.com"}, MaxAge: "12 hours"},
+		"no origins":     {MaxAge: "1h"},
+		"all and a list": {AllowAllOrigins: true, AllowOrigins: []string{"https://app.example.com"}},
+		"origin regex":   {AllowOriginRegex: []string{"("}},
+		"proxy":          {AllowOrigins: []string{"https://app.example.com"}, TrustedProxies: []string{"10.0.0.0/33"}},
+	} {
+		if _, err = option.Config(); err == nil {
+			t.Errorf("%s: invalid option accepted", name)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cors.yaml")
+	writeFile(t, file, "allow_origins: [\"https://a.example.com\"]\n")
+	c := NewYamlCorsMiddleware()
+	c.options = yamlOption(file)
+	if err := c.reload(file); err != nil {
+		t.Fatal(err)
+	}
+	// a controller pinning its methods follows the reloaded origins
+	p := c.override(policyKey("Orders", ""), policyParams{methods: []string{"GET"}})
+	if !allowsOrigin(c.current.Load(), "https://a.example.com") || !allowsOrigin(c.load(p), "https://a.example.com") {
+		t.Fatal("origin of the file not allowed")
+	}
+
+	writeFile(t, file, "allow_origins: [\"https://b.example.com\"]\n")
+	if err := c.reload(file); err != nil {
+		t.Fatal(err)
+	}
+	for name, cors := range map[string]*cors{"global": c.current.Load(), "override": c.load(p)} {
+		if allowsOrigin(cors, "https://a.example.com") || !allowsOrigin(cors, "https://b.example.com") {
+			t.Errorf("%s: policy not swapped", name)
+		}
+	}
+
+	// an invalid file keeps the running policy
+	writeFile(t, file, "allow_origins: [\"https://c.example.com\"]\nmax_age: soon\n")
+	if err := c.reload(file); err == nil {
+		t.Fatal("invalid max_age reloaded")
+	}
+	if !allowsOrigin(c.current.Load(), "https://b.example.com") || !allowsOrigin(c.load(p), "https://b.example.com") {
+		t.Error("running policy replaced by an invalid file")
+	}
+
+	// the pattern of the override is only checked once wildcards are enabled,
+	// a file enabling them is applied to no route at all
+	c.override(policyKey("Reports", ""), policyParams{origins: []string{"https://*reports.example.com"}})
+	writeFile(t, file, "allow_origins: [\"https://d.example.com\"]\nallow_wildcard: true\n")
+	if err := c.reload(file); err == nil {
+		t.Fatal("file breaking an override reloaded")
+	}
+	if allowsOrigin(c.current.Load(), "https://d.example.com") || allowsOrigin(c.load(p), "https://d.example.com") {
+		t.Error("file breaking an override applied in part")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cors.yaml")
+	writeFile(t, file, "allow_origins: [\"https://a.example.com\"]\n")
+	c := NewYamlCorsMiddleware()
+	c.options = yamlOption(file)
+	version, err := statFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.reload(file); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		c.watch(file, version, time.Millisecond)
+		close(done)
+	}()
+
+	writeFile(t, file, "allow_origins: [\"https://changed.example.com\"]\n")
+	deadline := time.Now().Add(time.Second)
+	for !allowsOrigin(c.current.Load(), "https://changed.example.com") {
+		if time.Now().After(deadline) {
+			t.Fatal("change of the file not picked up")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("watch still running after Close")
+	}
+	// closing twice is fine
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
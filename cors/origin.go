@@ -0,0 +1,173 @@
+package cors
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ws":    "80",
+	"wss":   "443",
+}
+
+// parseOrigin splits scheme://host[:port] and lower cases scheme and host,
+// the port is empty when it is the default port of the scheme
+func parseOrigin(origin string) (scheme, host, port string, ok bool) {
+	scheme, rest, found := strings.Cut(origin, "://")
+	if !found || scheme == "" {
+		return "", "", "", false
+	}
+	scheme = strings.ToLower(scheme)
+	host, port, ok = parseHostPort(scheme, rest)
+	return scheme, host, port, ok
+}
+
+// parseHostPort splits host[:port] of scheme, see parseOrigin
+func parseHostPort(scheme, rest string) (host, port string, ok bool) {
+	if strings.HasPrefix(rest, "[") {
+		// ipv6 literal
+		end := strings.IndexByte(rest, ']')
+		if end < 0 {
+			return "", "", false
+		}
+		host, rest = rest[:end+1], rest[end+1:]
+		if rest != "" {
+			if rest[0] != ':' {
+				return "", "", false
+			}
+			port = rest[1:]
+		}
+	} else {
+		host, port, _ = strings.Cut(rest, ":")
+	}
+	if strings.ContainsAny(host, "/?#@") || strings.ContainsAny(port, "/?#@:") {
+		return "", "", false
+	}
+	host = strings.ToLower(host)
+	if port == defaultPorts[scheme] {
+		port = ""
+	}
+	return host, port, true
+}
+
+// isNormalized reports whether origin is scheme://host[:port] as returned by parseOrigin,
+// which browsers send, so it can be looked up without building the normalized string
+func isNormalized(origin, scheme, host, port string) bool {
+	n := len(scheme) + len("://") + len(host)
+	if port != "" {
+		n += len(":") + len(port)
+	}
+	return len(origin) == n && origin[:len(scheme)] == scheme && origin[len(scheme)+len("://"):][:len(host)] == host
+}
+
+// normalizeOrigin returns scheme://host[:port] without the default port, ok is false for opaque origins like null
+func normalizeOrigin(origin string) (string, bool) {
+	scheme, host, port, ok := parseOrigin(origin)
+	if !ok {
+		return "", false
+	}
+	if port == "" {
+		return scheme + "://" + host, true
+	}
+	return scheme + "://" + host + ":" + port, true
+}
+
+// originPattern is an allowed origin with wildcards, e.g. https://*.example.com, https://api-*.example.com,
+// https://api.*.example.com or http://localhost:*. a * matches one host label or the rest of a label after
+// a prefix, a leading *. matches one or more labels. a pattern without scheme matches any scheme.
+type originPattern struct {
+	raw    string
+	rule   string // "pattern " + raw, reported by matchOrigin
+	scheme string // * for any scheme
+	// labels of the host without the leading *. of subdomain patterns, split at *
+	labels [][]string
+	// subdomains is true for a leading *., the pattern then matches hosts with more labels in front
+	subdomains bool
+	// port is * for any port
+	port string
+}
+
+func parseOriginPattern(pattern string) (*originPattern, error) {
+	raw := pattern
+	// origins have no path, http://example.com/* is the same as http://example.com
+	pattern = strings.TrimSuffix(strings.TrimSuffix(pattern, "/*"), "/")
+	if !strings.Contains(pattern, "://") {
+		pattern = "*://" + pattern
+	}
+	scheme, host, port, ok := parseOrigin(pattern)
+	if !ok || host == "" {
+		return nil, errors.New("bad origin: " + pattern)
+	}
+	p := &originPattern{raw: raw, rule: "pattern " + raw, scheme: scheme, port: port}
+	if rest, found := strings.CutPrefix(host, "*."); found {
+		p.subdomains = true
+		host = rest
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" {
+			return nil, errors.New("bad origin: empty host label in " + pattern)
+		}
+		// *example.com would match evil-example.com
+		if label != "*" && strings.HasPrefix(label, "*") {
+			return nil, errors.New("bad origin: * must be a whole label or follow a prefix in " + pattern + ", use *.domain for subdomains")
+		}
+		// split once, matching runs on every request
+		p.labels = append(p.labels, strings.Split(label, "*"))
+	}
+	return p, nil
+}
+
+// matchLabel matches a host label against the parts of a pattern label split at *, * matches any characters
+func matchLabel(parts []string, label string) bool {
+	if len(parts) == 1 {
+		return parts[0] == label
+	}
+	if !strings.HasPrefix(label, parts[0]) {
+		return false
+	}
+	label = label[len(parts[0]):]
+	last := len(parts) - 1
+	for _, part := range parts[1:last] {
+		i := strings.Index(label, part)
+		if i < 0 {
+			return false
+		}
+		label = label[i+len(part):]
+	}
+	return strings.HasSuffix(label, parts[last])
+}
+
+func (p *originPattern) match(scheme, host, port string) bool {
+	if (p.scheme != "*" && scheme != p.scheme) || (p.port != "*" && port != p.port) {
+		return false
+	}
+	n := strings.Count(host, ".") + 1
+	if p.subdomains {
+		// at least one more label, so *.example.com matches neither example.com nor evil-example.com
+		if n <= len(p.labels) {
+			return false
+		}
+	} else if n != len(p.labels) {
+		return false
+	}
+	// compare from the last label, the extra labels of subdomains are left over
+	rest := host
+	for i := len(p.labels) - 1; i >= 0; i-- {
+		label := rest
+		if j := strings.LastIndexByte(rest, '.'); j >= 0 {
+			label, rest = rest[j+1:], rest[:j]
+		}
+		if label == "" || !matchLabel(p.labels[i], label) {
+			return false
+		}
+	}
+	return true
+}
+
+// compileOriginRegex anchors the expression so it has to match the whole origin
+func compileOriginRegex(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + expr + ")$")
+}
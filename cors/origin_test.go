@@ -0,0 +1,32 @@
+package cors
+
+import "testing"
+
+func TestOriginPatternMatch(t *testing.T) {
+	for _, tt := range []struct {
+		pattern string
+		origin  string
+		want    bool
+	}{
+		{"https://*.example.com", "https://a.example.com", true},
+		{"https://*.example.com", "https://a.b.example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "https://evil-example.com", false},
+		{"https://*.example.com", "http://a.example.com", false},
+		{"https://api-*.example.com", "https://api-v2.example.com", true},
+		{"https://api-*.example.com", "https://web.example.com", false},
+		{"https://api.*.example.com", "https://api.eu.example.com", true},
+		{"https://api.*.example.com", "https://api.eu.west.example.com", false},
+		{"http://localhost:*", "http://localhost:5173", true},
+		{"*.example.com", "wss://a.example.com", true},
+	} {
+		p, err := parseOriginPattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.pattern, err)
+		}
+		scheme, host, port, ok := parseOrigin(tt.origin)
+		if got := ok && p.match(scheme, host, port); got != tt.want {
+			t.Errorf("%s matches %s = %v, want %v", tt.pattern, tt.origin, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package cors
+
+import (
+	"fmt"
+	"github.com/linxlib/fw"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// context key set once a policy has been applied to the request
+const appliedKey = "cors_applied"
+
+// policyParams are the attribute params of CorsCtlMiddleware, empty params keep the global value
+type policyParams struct {
+	origins        []string
+	methods        []string
+	headers        []string
+	expose         []string
+	credentials    string
+	reflectHeaders string
+	privateNetwork string
+	wildcard       string
+	maxAge         string
+	debug          string
+	nullOrigin     string
+}
+
+func splitParam(v string) []string {
+	if v == "" {
+		return nil
+	}
+	values := strings.Split(v, ",")
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	return values
+}
+
+func parsePolicyParams(ctx *fw.MiddlewareContext) policyParams {
+	return policyParams{
+		origins:        splitParam(ctx.GetParam("origins")),
+		methods:        splitParam(ctx.GetParam("methods")),
+		headers:        splitParam(ctx.GetParam("headers")),
+		expose:         splitParam(ctx.GetParam("expose")),
+		credentials:    ctx.GetParam("credentials"),
+		reflectHeaders: ctx.GetParam("reflect_headers"),
+		privateNetwork: ctx.GetParam("private_network"),
+		wildcard:       ctx.GetParam("wildcard"),
+		maxAge:         ctx.GetParam("max_age"),
+		debug:          ctx.GetParam("debug"),
+		nullOrigin:     ctx.GetParam("null_origin"),
+	}
+}
+
+func parseBoolParam(name, v string, target *bool) error {
+	if v == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("cors: invalid %s: %w", name, err)
+	}
+	*target = b
+	return nil
+}
+
+// apply returns base overridden by the params
+func (p policyParams) apply(base Config) (Config, error) {
+	config := base
+	if len(p.origins) > 0 {
+		// an override is a complete origin policy, the global funcs would widen it
+		config.AllowOriginFunc = nil
+		config.AllowOriginWithContextFunc = nil
+		config.AllowAllOrigins = false
+		config.AllowOrigins = nil
+		config.AllowOriginRegex = nil
+		config.AllowOriginSource = nil
+		for _, origin := range p.origins {
+			if origin == "*" {
+				config.AllowAllOrigins = true
+				config.AllowOrigins = nil
+				break
+			}
+			config.AllowOrigins = append(config.AllowOrigins, origin)
+		}
+	}
+	if len(p.methods) > 0 {
+		config.AllowMethods = p.methods
+	}
+	if len(p.headers) > 0 {
+		config.AllowHeaders = p.headers
+	}
+	if len(p.expose) > 0 {
+		config.ExposeHeaders = p.expose
+	}
+	if err := parseBoolParam("credentials", p.credentials, &config.AllowCredentials); err != nil {
+		return config, err
+	}
+	if err := parseBoolParam("reflect_headers", p.reflectHeaders, &config.ReflectRequestHeaders); err != nil {
+		return config, err
+	}
+	if err := parseBoolParam("private_network", p.privateNetwork, &config.AllowPrivateNetwork); err != nil {
+		return config, err
+	}
+	if err := parseBoolParam("wildcard", p.wildcard, &config.AllowWildcard); err != nil {
+		return config, err
+	}
+	if err := parseBoolParam("debug", p.debug, &config.Debug); err != nil {
+		return config, err
+	}
+	if err := parseBoolParam("null_origin", p.nullOrigin, &config.AllowNullOrigin); err != nil {
+		return config, err
+	}
+	if p.maxAge != "" {
+		d, err := time.ParseDuration(p.maxAge)
+		if err != nil {
+			return config, fmt.Errorf("cors: invalid max_age: %w", err)
+		}
+		config.MaxAge = d
+	}
+	if err := config.Validate(); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// policy is a controller or method override of the global policy,
+// it is rebuilt when the global policy is reloaded. routes without an override share
+// a placeholder whose current is nil, they follow the global policy.
+type policy struct {
+	params     policyParams
+	overridden bool // guarded by CorsMiddleware.mu
+	current    atomic.Pointer[cors]
+}
+
+func policyKey(controller, method string) string {
+	return controller + "." + method
+}
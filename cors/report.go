@@ -0,0 +1,113 @@
+package cors
+
+import (
+	"github.com/linxlib/fw"
+	"github.com/linxlib/fw_middlewares/auth"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"sync"
+)
+
+const (
+	debugHeader = "X-CORS-Debug"
+
+	// distinct origins counted, the others are counted under otherOrigins
+	maxRejectedOrigins = 1000
+	otherOrigins       = "other"
+)
+
+type CorsAdminOption struct {
+	Key  string `yaml:"key" default:""` // sent in the X-Admin-Key header, the route is disabled when empty
+	Path string `yaml:"path" default:"/cors/rejects"`
+}
+
+// rejectEvent describes a request rejected by a cors policy
+type rejectEvent struct {
+	Origin         string
+	Method         string
+	Path           string
+	RequestMethod  string // Access-Control-Request-Method of preflight requests
+	RequestHeaders string // Access-Control-Request-Headers of preflight requests
+	Reason         string
+	Rule           string // the rule which allowed the origin when the method or headers were rejected
+	Debug          bool
+}
+
+// rejectCounter counts rejected requests per origin
+type rejectCounter struct {
+	counts map[string]int64
+	total  int64
+	mu     sync.Mutex
+}
+
+func newRejectCounter() *rejectCounter {
+	return &rejectCounter{counts: make(map[string]int64)}
+}
+
+func (r *rejectCounter) add(origin string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.total++
+	// origins come from clients, do not let them grow the map forever
+	if _, ok := r.counts[origin]; !ok && len(r.counts) >= maxRejectedOrigins {
+		origin = otherOrigins
+	}
+	r.counts[origin]++
+}
+
+func (r *rejectCounter) snapshot() fw.H {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	counts := make(map[string]int64, len(r.counts))
+	for origin, n := range r.counts {
+		counts[origin] = n
+	}
+	return fw.H{"total": r.total, "origins": counts}
+}
+
+func (r *rejectCounter) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.total = 0
+	r.counts = make(map[string]int64)
+}
+
+// report counts the rejected request and logs it in debug mode
+func (c *CorsMiddleware) report(event *rejectEvent) {
+	c.rejects.add(event.Origin)
+	if !event.Debug || c.Logger == nil {
+		return
+	}
+	c.Logger.WithFields(logrus.Fields{
+		"origin":          event.Origin,
+		"method":          event.Method,
+		"path":            event.Path,
+		"request_method":  event.RequestMethod,
+		"request_headers": event.RequestHeaders,
+		"reason":          event.Reason,
+		"rule":            event.Rule,
+	}).Warn("[Cors] rejected")
+}
+
+// adminRoutes lists and resets the reject counters
+func (c *CorsMiddleware) adminRoutes() []*fw.RouteItem {
+	return auth.AdminRoutes(c.admin.Key,
+		&fw.RouteItem{
+			Method: "GET",
+			Path:   c.admin.Path,
+			H: func(context *fw.Context) {
+				context.JSON(http.StatusOK, c.rejects.snapshot())
+			},
+			Middleware: c,
+		},
+		&fw.RouteItem{
+			Method: "DELETE",
+			Path:   c.admin.Path,
+			H: func(context *fw.Context) {
+				c.rejects.reset()
+				context.String(http.StatusOK, "ok")
+			},
+			Middleware: c,
+		},
+	)
+}
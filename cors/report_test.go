@@ -0,0 +1,109 @@
+package cors
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"strconv"
+	"testing"
+)
+
+func rejectsOf(c *CorsMiddleware) (int64, map[string]int64) {
+	snapshot := c.rejects.snapshot()
+	return snapshot["total"].(int64), snapshot["origins"].(map[string]int64)
+}
+
+func TestRejectsAreCounted(t *testing.T) {
+	c := NewCorsMiddleware(benchConfig())
+	c.current.Store(c.build(c.config))
+	p := c.override(policyKey("Orders", ""), policyParams{methods: []string{"GET"}})
+
+	c.current.Load().apply(corsRequest("GET", "https://app.example.com", false), nil)
+	c.current.Load().apply(corsRequest("GET", "", false), nil)
+	if total, _ := rejectsOf(c); total != 0 {
+		t.Fatalf("allowed requests counted as %d rejects", total)
+	}
+
+	c.current.Load().apply(corsRequest("GET", "https://evil.example.org", false), nil)
+	c.current.Load().apply(corsRequest("POST", "https://evil.example.org", false), nil)
+	// rejected by the override for its method, the origin is allowed
+	c.load(p).apply(preflight("DELETE", ""), nil)
+	total, origins := rejectsOf(c)
+	if total != 3 || origins["https://evil.example.org"] != 2 || origins["https://app.example.com"] != 1 {
+		t.Fatalf("total %d, origins %v", total, origins)
+	}
+
+	// the snapshot is a copy
+	origins["https://evil.example.org"] = 100
+	if _, origins = rejectsOf(c); origins["https://evil.example.org"] != 2 {
+		t.Fatal("snapshot shares the counters")
+	}
+
+	c.rejects.reset()
+	if total, origins = rejectsOf(c); total != 0 || len(origins) != 0 {
+		t.Fatalf("after reset: total %d, origins %v", total, origins)
+	}
+}
+
+func TestRejectedOriginsAreCapped(t *testing.T) {
+	r := newRejectCounter()
+	for i := 0; i < maxRejectedOrigins+10; i++ {
+		r.add("https://" + strconv.Itoa(i) + ".example.org")
+	}
+	// origins seen before the cap keep their own counter
+	r.add("https://0.example.org")
+	snapshot := r.snapshot()
+	origins := snapshot["origins"].(map[string]int64)
+	if len(origins) != maxRejectedOrigins+1 {
+		t.Fatalf("%d origins counted, want %d", len(origins), maxRejectedOrigins+1)
+	}
+	if origins[otherOrigins] != 10 || origins["https://0.example.org"] != 2 {
+		t.Fatalf("other %d, first origin %d", origins[otherOrigins], origins["https://0.example.org"])
+	}
+	if total := snapshot["total"].(int64); total != maxRejectedOrigins+11 {
+		t.Fatalf("total %d", total)
+	}
+}
+
+func TestRejectsAreLoggedInDebugMode(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	config := benchConfig()
+	c := NewCorsMiddleware(config)
+	c.Logger = logger
+	c.current.Store(c.build(config))
+	c.current.Load().apply(corsRequest("GET", "https://evil.example.org", false), nil)
+	if n := len(hook.AllEntries()); n != 0 {
+		t.Fatalf("%d entries logged without debug", n)
+	}
+
+	config.Debug = true
+	c.current.Store(c.build(config))
+	c.current.Load().apply(preflight("CONNECT", ""), nil)
+	entry := hook.LastEntry()
+	if entry == nil || entry.Level != logrus.WarnLevel {
+		t.Fatalf("reject not logged: %v", entry)
+	}
+	if entry.Data["reason"] != "method CONNECT not allowed" || entry.Data["rule"] != "origin https://app.example.com" ||
+		entry.Data["request_method"] != "CONNECT" {
+		t.Fatalf("logged %v", entry.Data)
+	}
+	if total, _ := rejectsOf(c); total != 2 {
+		t.Fatalf("%d rejects counted, debug mode does not change counting", total)
+	}
+}
+
+func TestAdminRoutesNeedKey(t *testing.T) {
+	c := NewCorsMiddleware(benchConfig())
+	if routes := c.adminRoutes(); routes != nil {
+		t.Fatalf("admin routes registered without a key: %d", len(routes))
+	}
+	c.admin = &CorsAdminOption{Key: "secret", Path: "/cors/rejects"}
+	routes := c.adminRoutes()
+	if len(routes) != 2 {
+		t.Fatalf("%d admin routes, want 2", len(routes))
+	}
+	for _, route := range routes {
+		if !route.IsHide || route.Path != "/cors/rejects" {
+			t.Errorf("%s %s: hidden %v", route.Method, route.Path, route.IsHide)
+		}
+	}
+}
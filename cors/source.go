@@ -0,0 +1,226 @@
+package cors
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultOriginSourceTTL is how long the origins of a source are cached when no ttl is given
+const DefaultOriginSourceTTL = time.Minute
+
+// OriginSource returns the allowed origins, e.g. the frontend domains tenants registered in the database.
+// the origins are exact, wildcards and regular expressions are not expanded. ctx is the request which
+// needs the origins, or a background context cancelled by CachedOriginSource.Stop.
+type OriginSource interface {
+	Origins(ctx context.Context) ([]string, error)
+}
+
+// OriginSourceFunc adapts a function to an OriginSource
+type OriginSourceFunc func(ctx context.Context) ([]string, error)
+
+func (f OriginSourceFunc) Origins(ctx context.Context) ([]string, error) {
+	return f(ctx)
+}
+
+type originSet struct {
+	origins map[string]bool // normalized by normalizeOrigin
+	list    []string
+}
+
+// CachedOriginSource caches the origins of a source for ttl. expired origins are refreshed in the background
+// while the cached ones are still used, when the source fails the last good origins are kept and the source
+// is asked again after ttl. Start refreshes them periodically instead of on demand.
+type CachedOriginSource struct {
+	source   OriginSource
+	ttl      time.Duration
+	interval time.Duration // of Start, 0 when not started
+
+	current    atomic.Pointer[originSet]
+	checked    atomic.Int64 // unix nano of the last attempt
+	refreshing atomic.Bool
+	onError    atomic.Pointer[func(err error)]
+	lastErr    error
+	mu         sync.Mutex // serializes calls to the source
+
+	// of background refreshes, cancelled by Stop
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewCachedOriginSource caches source for ttl, DefaultOriginSourceTTL when ttl is not positive.
+// onError is called with the errors of the source, the last good origins are still used.
+func NewCachedOriginSource(source OriginSource, ttl time.Duration, onError ...func(err error)) *CachedOriginSource {
+	if ttl <= 0 {
+		ttl = DefaultOriginSourceTTL
+	}
+	s := &CachedOriginSource{source: source, ttl: ttl}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	if len(onError) > 0 && onError[0] != nil {
+		s.onError.Store(&onError[0])
+	}
+	return s
+}
+
+// Origins returns the cached origins, normalized
+func (s *CachedOriginSource) Origins(ctx context.Context) ([]string, error) {
+	set, err := s.load(ctx)
+	if set == nil {
+		return nil, err
+	}
+	return slices.Clone(set.list), nil
+}
+
+// Refresh asks the source now, the cached origins are kept when it fails
+func (s *CachedOriginSource) Refresh(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.refresh(ctx)
+	return err
+}
+
+// Start refreshes the origins every interval until Stop is called
+func (s *CachedOriginSource) Start(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	s.interval = interval
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = s.Refresh(s.ctx)
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the periodic refresh of Start and cancels a running background refresh
+func (s *CachedOriginSource) Stop() {
+	s.cancel()
+}
+
+// handleErrors sets the error handler unless one is set already
+func (s *CachedOriginSource) handleErrors(fn func(err error)) {
+	s.onError.CompareAndSwap(nil, &fn)
+}
+
+// allowed reports whether the normalized origin is one of the origins
+func (s *CachedOriginSource) allowed(ctx context.Context, origin string) bool {
+	set, _ := s.load(ctx)
+	return set != nil && set.origins[origin]
+}
+
+func (s *CachedOriginSource) expired() bool {
+	return time.Since(time.Unix(0, s.checked.Load())) >= s.ttl
+}
+
+// load returns the cached origins, ctx is only used when there are none yet
+func (s *CachedOriginSource) load(ctx context.Context) (*originSet, error) {
+	if set := s.current.Load(); set != nil {
+		if s.expired() && s.refreshing.CompareAndSwap(false, true) {
+			go func() {
+				defer s.refreshing.Store(false)
+				// the request which noticed the expiry does not wait for it
+				_ = s.Refresh(s.ctx)
+			}()
+		}
+		return set, nil
+	}
+	// nothing to fall back to, wait for the source
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if set := s.current.Load(); set != nil {
+		return set, nil
+	}
+	if !s.expired() {
+		// the source failed recently, do not ask it on every request
+		return nil, s.lastErr
+	}
+	return s.refresh(ctx)
+}
+
+// refresh asks the source, s.mu must be held
+func (s *CachedOriginSource) refresh(ctx context.Context) (*originSet, error) {
+	checked := s.checked.Swap(time.Now().UnixNano())
+	origins, err := s.source.Origins(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			// the caller gave up, that says nothing about the source
+			s.checked.Store(checked)
+			return s.current.Load(), err
+		}
+		s.lastErr = err
+		if fn := s.onError.Load(); fn != nil {
+			(*fn)(err)
+		}
+		return s.current.Load(), err
+	}
+	s.lastErr = nil
+	set := &originSet{origins: make(map[string]bool, len(origins)), list: make([]string, 0, len(origins))}
+	for _, origin := range origins {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		normalized, ok := normalizeOrigin(origin)
+		if !ok {
+			normalized = strings.ToLower(origin)
+		}
+		if !set.origins[normalized] {
+			set.origins[normalized] = true
+			set.list = append(set.list, normalized)
+		}
+	}
+	s.current.Store(set)
+	return set, nil
+}
+
+var (
+	originSources   = make(map[string]OriginSource)
+	cachedSources   = make(map[string]*CachedOriginSource)
+	originSourcesMu sync.Mutex
+)
+
+// RegisterOriginSource makes the source available to the allow_origin_source option of the cors section
+func RegisterOriginSource(name string, source OriginSource) {
+	originSourcesMu.Lock()
+	defer originSourcesMu.Unlock()
+	originSources[name] = source
+	if cached, ok := cachedSources[name]; ok {
+		cached.Stop()
+		delete(cachedSources, name)
+	}
+}
+
+// cachedOriginSource returns the cache of a registered source, reloads of the config share it
+// as long as ttl and refresh do not change
+func cachedOriginSource(name string, ttl time.Duration, refresh time.Duration) (*CachedOriginSource, error) {
+	originSourcesMu.Lock()
+	defer originSourcesMu.Unlock()
+	source, ok := originSources[name]
+	if !ok {
+		return nil, errors.New("cors: origin source " + name + " is not registered")
+	}
+	if ttl <= 0 {
+		ttl = DefaultOriginSourceTTL
+	}
+	if cached, ok := cachedSources[name]; ok {
+		if cached.ttl == ttl && cached.interval == refresh {
+			return cached, nil
+		}
+		cached.Stop()
+	}
+	cached := NewCachedOriginSource(source, ttl)
+	cached.Start(refresh)
+	cachedSources[name] = cached
+	return cached, nil
+}
@@ -0,0 +1,62 @@
+package cors
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type ctxKey struct{}
+
+func TestCachedOriginSourceContext(t *testing.T) {
+	var calls atomic.Int32
+	source := OriginSourceFunc(func(ctx context.Context) ([]string, error) {
+		calls.Add(1)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if ctx.Value(ctxKey{}) != "request" {
+			return nil, errors.New("context of the request not passed")
+		}
+		return []string{"https://App.example.com:443"}, nil
+	})
+	cached := NewCachedOriginSource(source, time.Hour)
+	defer cached.Stop()
+
+	// a caller which gave up is not cached as a failure of the source
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cached.Origins(cancelled); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled err = %v", err)
+	}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+	origins, err := cached.Origins(ctx)
+	if err != nil || !slices.Equal(origins, []string{"https://app.example.com"}) {
+		t.Fatalf("origins = %v, %v", origins, err)
+	}
+	if !cached.allowed(ctx, "https://app.example.com") || calls.Load() != 2 {
+		t.Fatalf("allowed from cache failed, %d calls", calls.Load())
+	}
+}
+
+func TestCachedOriginSourceStop(t *testing.T) {
+	stopped := make(chan struct{})
+	source := OriginSourceFunc(func(ctx context.Context) ([]string, error) {
+		<-ctx.Done()
+		close(stopped)
+		return nil, ctx.Err()
+	})
+	cached := NewCachedOriginSource(source, time.Hour)
+	go func() {
+		_ = cached.Refresh(cached.ctx)
+	}()
+	cached.Stop()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not cancel the refresh")
+	}
+}
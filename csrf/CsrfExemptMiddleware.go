@@ -0,0 +1,41 @@
+package csrf
+
+import (
+	"github.com/linxlib/fw"
+)
+
+var _ fw.IMiddlewareMethod = (*CsrfExemptMiddleware)(nil)
+
+const (
+	csrfExemptAttr = "CsrfExempt"
+	csrfExemptName = "CsrfExempt"
+)
+
+// CsrfExemptMiddleware turns the csrf check of a method off, e.g. a webhook authenticated by its signature.
+// can be used on Method
+//
+//	// @CsrfExempt
+type CsrfExemptMiddleware struct {
+	*fw.MiddlewareMethod
+	csrf *CsrfMiddleware
+}
+
+func (e *CsrfExemptMiddleware) Execute(ctx *fw.MiddlewareContext) fw.HandlerFunc {
+	// marked while the routes are built, the csrf check of the controller reads it per request
+	e.csrf.exemption(ctx.ControllerName, ctx.MethodName).Store(true)
+	return func(context *fw.Context) {
+		ctx.Next(context)
+	}
+}
+
+// NewCsrfExemptMiddleware creates the opt-out of csrf, the middleware returned by NewCsrfMiddleware
+func NewCsrfExemptMiddleware(csrf fw.IMiddlewareCtl) fw.IMiddlewareMethod {
+	c, ok := csrf.(*CsrfMiddleware)
+	if !ok {
+		panic("csrf: NewCsrfExemptMiddleware needs the middleware of NewCsrfMiddleware")
+	}
+	return &CsrfExemptMiddleware{
+		MiddlewareMethod: fw.NewMiddlewareMethod(csrfExemptName, csrfExemptAttr),
+		csrf:             c,
+	}
+}
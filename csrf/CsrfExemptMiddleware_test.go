@@ -0,0 +1,19 @@
+package csrf
+
+import (
+	"github.com/linxlib/fw"
+	"testing"
+)
+
+func TestCsrfExempt(t *testing.T) {
+	c := NewCsrfMiddleware().(*CsrfMiddleware)
+	// the check of the controller is built before the attribute of the method marks it
+	webhook := c.exemption("Hooks", "Github")
+	NewCsrfExemptMiddleware(c).Execute(&fw.MiddlewareContext{ControllerName: "Hooks", MethodName: "Github"})
+	if !webhook.Load() {
+		t.Fatal("Hooks.Github is not exempt")
+	}
+	if c.exemption("Hooks", "List").Load() {
+		t.Fatal("Hooks.List is exempt")
+	}
+}
@@ -0,0 +1,240 @@
+package csrf
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"github.com/linxlib/conv"
+	"github.com/linxlib/fw"
+	"github.com/linxlib/fw_middlewares/auth"
+	"github.com/linxlib/fw_middlewares/session"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var _ fw.IMiddlewareCtl = (*CsrfMiddleware)(nil)
+
+const (
+	csrfAttr = "Csrf"
+	csrfName = "Csrf"
+
+	// TokenKey is the context key of the token a page or SPA should send back
+	TokenKey = "csrf_token"
+
+	ModeDoubleSubmit = "double_submit"
+	ModeSynchronizer = "synchronizer"
+
+	// session key of the synchronizer token
+	sessionTokenKey = "_csrf_token"
+)
+
+type CsrfOption struct {
+	Mode           string   `yaml:"mode" default:"double_submit"`       // double_submit or synchronizer (needs SessionMiddleware)
+	Secret         string   `yaml:"secret" default:""`                  // signs double submit tokens when set
+	HeaderName     string   `yaml:"header_name" default:"X-CSRF-Token"` // request header carrying the token
+	FormField      string   `yaml:"form_field" default:"_csrf"`         // form field carrying the token
+	CookieName     string   `yaml:"cookie_name" default:"csrf_token"`   // double submit cookie, readable by js
+	CookiePath     string   `yaml:"cookie_path" default:"/"`
+	CookieDomain   string   `yaml:"cookie_domain" default:""`
+	CookieSecure   bool     `yaml:"cookie_secure" default:"false"`
+	TrustedOrigins []string `yaml:"trusted_origins"` // extra origins allowed for unsafe requests, e.g. https://admin.example.com
+}
+
+// CsrfMiddleware protects unsafe requests of cookie authenticated apis from cross site requests.
+// can be used on Controller, methods with CsrfExemptMiddleware are not checked
+//
+//	// @Csrf mode=synchronizer
+type CsrfMiddleware struct {
+	*fw.MiddlewareCtl
+	options *CsrfOption
+	exempt  map[string]*atomic.Bool // by controller and method, set by CsrfExemptMiddleware
+	mu      sync.Mutex
+}
+
+func (c *CsrfMiddleware) DoInitOnce() {
+	c.LoadConfig("csrf", c.options)
+}
+
+// exemption returns the flag of a method, the same one for CsrfMiddleware and CsrfExemptMiddleware
+// whichever runs first
+func (c *CsrfMiddleware) exemption(controller, method string) *atomic.Bool {
+	key := controller + "." + method
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	exempt, ok := c.exempt[key]
+	if !ok {
+		exempt = new(atomic.Bool)
+		c.exempt[key] = exempt
+	}
+	return exempt
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (c *CsrfMiddleware) sign(token string) string {
+	mac := hmac.New(sha256.New, []byte(c.options.Secret))
+	mac.Write([]byte(token))
+	return token + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// validSigned checks the signature of a double submit token when a secret is configured,
+// so a cookie planted by a sibling subdomain can not be used
+func (c *CsrfMiddleware) validSigned(token string) bool {
+	if c.options.Secret == "" {
+		return token != ""
+	}
+	raw, _, found := strings.Cut(token, ".")
+	return found && hmac.Equal([]byte(c.sign(raw)), []byte(token))
+}
+
+func (c *CsrfMiddleware) newToken() string {
+	token := randomToken()
+	if c.options.Secret != "" {
+		token = c.sign(token)
+	}
+	return token
+}
+
+func (c *CsrfMiddleware) setCookie(fctx *fasthttp.RequestCtx, token string) {
+	cookie := fasthttp.AcquireCookie()
+	defer fasthttp.ReleaseCookie(cookie)
+	cookie.SetKey(c.options.CookieName)
+	cookie.SetValue(token)
+	cookie.SetPath(c.options.CookiePath)
+	cookie.SetDomain(c.options.CookieDomain)
+	cookie.SetSecure(c.options.CookieSecure)
+	// must be readable by the SPA to send it back in the header
+	cookie.SetHTTPOnly(false)
+	cookie.SetSameSite(fasthttp.CookieSameSiteLaxMode)
+	fctx.Response.Header.SetCookie(cookie)
+}
+
+// requestToken reads the token from the header or the form field
+func (c *CsrfMiddleware) requestToken(fctx *fasthttp.RequestCtx) string {
+	if v := fctx.Request.Header.Peek(c.options.HeaderName); len(v) > 0 {
+		return conv.String(v)
+	}
+	if c.options.FormField != "" {
+		if v := fctx.PostArgs().Peek(c.options.FormField); len(v) > 0 {
+			return conv.String(v)
+		}
+		if form, err := fctx.MultipartForm(); err == nil {
+			if v := form.Value[c.options.FormField]; len(v) > 0 {
+				return v[0]
+			}
+		}
+	}
+	return ""
+}
+
+func forbidden(context *fw.Context, reason string) {
+	context.JSON(http.StatusForbidden, fw.H{"error": "csrf: " + reason})
+}
+
+func (c *CsrfMiddleware) Execute(ctx *fw.MiddlewareContext) fw.HandlerFunc {
+	mode := c.options.Mode
+	if v := ctx.GetParam("mode"); v != "" {
+		mode = v
+	}
+	exempt := c.exemption(ctx.ControllerName, ctx.MethodName)
+	check := c.doubleSubmit(ctx)
+	if mode == ModeSynchronizer {
+		check = c.synchronizer(ctx)
+	}
+	return func(context *fw.Context) {
+		if exempt.Load() {
+			ctx.Next(context)
+			return
+		}
+		check(context)
+	}
+}
+
+func (c *CsrfMiddleware) doubleSubmit(ctx *fw.MiddlewareContext) fw.HandlerFunc {
+	return func(context *fw.Context) {
+		fctx := context.GetFastContext()
+		cookie := conv.String(fctx.Request.Header.Cookie(c.options.CookieName))
+		if isSafeMethod(conv.String(fctx.Method())) {
+			if !c.validSigned(cookie) {
+				cookie = c.newToken()
+				c.setCookie(fctx, cookie)
+			}
+			context.Set(TokenKey, cookie)
+			ctx.Next(context)
+			return
+		}
+		if reason, ok := auth.CheckOrigin(fctx, c.options.TrustedOrigins); !ok {
+			forbidden(context, reason)
+			return
+		}
+		token := c.requestToken(fctx)
+		if !c.validSigned(cookie) || token == "" ||
+			subtle.ConstantTimeCompare(conv.Bytes(cookie), conv.Bytes(token)) != 1 {
+			forbidden(context, "token mismatch")
+			return
+		}
+		context.Set(TokenKey, cookie)
+		ctx.Next(context)
+	}
+}
+
+func (c *CsrfMiddleware) synchronizer(ctx *fw.MiddlewareContext) fw.HandlerFunc {
+	return func(context *fw.Context) {
+		fctx := context.GetFastContext()
+		sess := session.FromContext(context)
+		if sess == nil {
+			context.JSON(http.StatusInternalServerError, fw.H{"error": "csrf: synchronizer mode requires SessionMiddleware"})
+			return
+		}
+		expected := sess.GetString(sessionTokenKey)
+		if isSafeMethod(conv.String(fctx.Method())) {
+			if expected == "" {
+				expected = randomToken()
+				sess.Set(sessionTokenKey, expected)
+			}
+			context.Set(TokenKey, expected)
+			fctx.Response.Header.Set(c.options.HeaderName, expected)
+			ctx.Next(context)
+			return
+		}
+		if reason, ok := auth.CheckOrigin(fctx, c.options.TrustedOrigins); !ok {
+			forbidden(context, reason)
+			return
+		}
+		token := c.requestToken(fctx)
+		if expected == "" || token == "" ||
+			subtle.ConstantTimeCompare(conv.Bytes(expected), conv.Bytes(token)) != 1 {
+			forbidden(context, "token mismatch")
+			return
+		}
+		context.Set(TokenKey, expected)
+		ctx.Next(context)
+	}
+}
+
+func NewCsrfMiddleware() fw.IMiddlewareCtl {
+	return &CsrfMiddleware{
+		MiddlewareCtl: fw.NewMiddlewareCtl(csrfName, csrfAttr),
+		options:       new(CsrfOption),
+		exempt:        make(map[string]*atomic.Bool),
+	}
+}
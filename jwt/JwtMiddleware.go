@@ -0,0 +1,184 @@
+package jwt
+
+import (
+	"errors"
+	"github.com/linxlib/conv"
+	"github.com/linxlib/fw"
+	"github.com/linxlib/fw_middlewares/auth"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var _ fw.IMiddlewareCtl = (*JwtMiddleware)(nil)
+
+const (
+	jwtAttr = "JWT"
+	jwtName = "JWT"
+
+	// ClaimsKey is the context key of the validated Claims
+	ClaimsKey = "jwt_claims"
+)
+
+type JwtOption struct {
+	Secret       string   `yaml:"secret" default:""`            // HS256 shared secret
+	PublicKey    string   `yaml:"public_key" default:""`        // PEM public key or certificate file for RS256/ES256/EdDSA
+	Jwks         string   `yaml:"jwks" default:""`              // JWKS file path or url, takes precedence over secret and public_key
+	JwksCacheTTL string   `yaml:"jwks_cache_ttl" default:"10m"` // how long the JWKS is cached
+	Algorithms   []string `yaml:"algorithms"`                   // accepted algorithms, all supported ones when empty
+	Issuer       string   `yaml:"issuer" default:""`            // expected iss, skipped when empty
+	Audience     string   `yaml:"audience" default:""`          // expected aud, skipped when empty
+	Leeway       string   `yaml:"leeway" default:"30s"`         // clock skew allowed for exp and nbf
+	RequireExp   bool     `yaml:"require_exp" default:"true"`   // tokens without exp are rejected, set false for tokens which never expire
+	Realm        string   `yaml:"realm" default:""`
+}
+
+// JwtMiddleware validates Bearer tokens and maps the claims into the context,
+// so methods can receive jwt.Claims as a parameter.
+// can be used on Controller
+//
+//	// @JWT issuer=https://idp.example.com audience=orders
+//	// @JWT require_exp=false
+type JwtMiddleware struct {
+	*fw.MiddlewareCtl
+	options *JwtOption
+	key     KeyFunc
+}
+
+func (j *JwtMiddleware) DoInitOnce() {
+	j.LoadConfig("jwt", j.options)
+	if len(j.options.Algorithms) == 0 {
+		j.options.Algorithms = []string{HS256, RS256, ES256, EdDSA}
+	}
+	if j.key != nil {
+		return
+	}
+	switch {
+	case j.options.Jwks != "":
+		ttl, err := time.ParseDuration(j.options.JwksCacheTTL)
+		if err != nil {
+			panic("jwt: invalid jwks_cache_ttl: " + err.Error())
+		}
+		j.key = NewJWKS(j.options.Jwks, ttl).Key
+	case j.options.PublicKey != "":
+		pub, err := loadPublicKey(j.options.PublicKey)
+		if err != nil {
+			panic("jwt: " + err.Error())
+		}
+		j.key = staticKey(pub)
+	case j.options.Secret != "":
+		j.key = staticKey([]byte(j.options.Secret))
+	default:
+		panic("jwt: one of jwks, public_key or secret must be configured")
+	}
+}
+
+// bearerError writes a RFC 6750 error response
+func bearerError(context *fw.Context, realm string, status int, code string, desc string) {
+	value := "Bearer"
+	params := make([]string, 0, 3)
+	if realm != "" {
+		params = append(params, "realm="+strconv.Quote(realm))
+	}
+	if code != "" {
+		params = append(params, "error="+strconv.Quote(code))
+	}
+	if desc != "" {
+		params = append(params, "error_description="+strconv.Quote(desc))
+	}
+	if len(params) > 0 {
+		value += " " + strings.Join(params, ", ")
+	}
+	context.GetFastContext().Response.Header.Set("WWW-Authenticate", value)
+	if code == "" {
+		code = "unauthorized"
+	}
+	context.JSON(status, fw.H{"error": code, "error_description": desc})
+}
+
+func (j *JwtMiddleware) Execute(ctx *fw.MiddlewareContext) fw.HandlerFunc {
+	validator := &Validator{
+		Algorithms: j.options.Algorithms,
+		Issuer:     j.options.Issuer,
+		Audience:   j.options.Audience,
+		RequireExp: j.options.RequireExp,
+		Key:        j.key,
+	}
+	if v := ctx.GetParam("require_exp"); v != "" {
+		validator.RequireExp = v == "true"
+	}
+	if v := ctx.GetParam("issuer"); v != "" {
+		validator.Issuer = v
+	}
+	if v := ctx.GetParam("audience"); v != "" {
+		validator.Audience = v
+	}
+	leeway := j.options.Leeway
+	if v := ctx.GetParam("leeway"); v != "" {
+		leeway = v
+	}
+	if leeway != "" {
+		d, err := time.ParseDuration(leeway)
+		if err != nil {
+			panic("jwt: invalid leeway: " + err.Error())
+		}
+		validator.Leeway = d
+	}
+	realm := j.options.Realm
+	if v := ctx.GetParam("realm"); v != "" {
+		realm = v
+	}
+	return func(context *fw.Context) {
+		header := conv.String(context.GetFastContext().Request.Header.Peek("Authorization"))
+		if header == "" {
+			// no credentials, the error code should not be included
+			bearerError(context, realm, http.StatusUnauthorized, "", "")
+			return
+		}
+		scheme, token, found := strings.Cut(header, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+			bearerError(context, realm, http.StatusBadRequest, "invalid_request", "authorization header must be Bearer token")
+			return
+		}
+		claims, err := validator.Parse(strings.TrimSpace(token))
+		if err != nil {
+			desc := err.Error()
+			if !isTokenError(err) {
+				// do not leak key loading errors to clients
+				desc = "token could not be verified"
+			}
+			bearerError(context, realm, http.StatusUnauthorized, "invalid_token", desc)
+			return
+		}
+		context.Set(ClaimsKey, claims)
+		context.Map(claims)
+		auth.SetPrincipal(context, claims.Principal(auth.MethodJWT))
+		ctx.Next(context)
+	}
+}
+
+func isTokenError(err error) bool {
+	for _, e := range []error{
+		ErrTokenMalformed, ErrTokenSignature, ErrTokenAlgorithm, ErrTokenKeyNotFound,
+		ErrTokenExpired, ErrTokenNotValidYet, ErrTokenIssuer, ErrTokenAudience, ErrTokenMissingClaim,
+	} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewJwtMiddleware creates the middleware configured by the jwt section,
+// a custom KeyFunc can be given instead of secret/public_key/jwks.
+func NewJwtMiddleware(key ...KeyFunc) fw.IMiddlewareCtl {
+	j := &JwtMiddleware{
+		MiddlewareCtl: fw.NewMiddlewareCtl(jwtName, jwtAttr),
+		options:       new(JwtOption),
+	}
+	if len(key) > 0 {
+		j.key = key[0]
+	}
+	return j
+}
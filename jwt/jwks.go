@@ -0,0 +1,314 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// jwk is a single JSON Web Key, only the fields needed for verification
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeSegment(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeSegment(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeSegment(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid P-256 coordinates")
+		}
+		// make sure the point is on the curve
+		point := append(append([]byte{4}, x...), y...)
+		if _, err = ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		return decodeSegment(k.K)
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+// algMatches reports whether a key of kty can verify alg
+func algMatches(alg string, key crypto.PublicKey) bool {
+	switch key.(type) {
+	case []byte:
+		return alg == HS256
+	case *rsa.PublicKey:
+		return alg == RS256
+	case *ecdsa.PublicKey:
+		return alg == ES256
+	case ed25519.PublicKey:
+		return alg == EdDSA
+	}
+	return false
+}
+
+// keySet is the usable keys of a JWKS document
+type keySet struct {
+	keys      map[string]crypto.PublicKey
+	anonymous []crypto.PublicKey
+}
+
+func (s *keySet) find(alg string, kid string) (crypto.PublicKey, bool) {
+	if kid != "" {
+		key, ok := s.keys[kid]
+		return key, ok && algMatches(alg, key)
+	}
+	for _, key := range s.anonymous {
+		if algMatches(alg, key) {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// JWKS loads a key set from a file or url and caches it for ttl. expired keys are refreshed in the background
+// while the cached ones are still used, when the source fails the cached keys are kept and it is asked again
+// after ttl. An unknown kid triggers a refresh, at most once per minRefresh.
+type JWKS struct {
+	source     string
+	ttl        time.Duration
+	minRefresh time.Duration
+	client     *http.Client
+
+	current    atomic.Pointer[keySet]
+	checked    atomic.Int64 // unix nano of the last attempt
+	refreshing atomic.Bool
+	lastErr    error
+	mu         sync.Mutex // serializes fetches
+}
+
+func NewJWKS(source string, ttl time.Duration) *JWKS {
+	return &JWKS{
+		source:     source,
+		ttl:        ttl,
+		minRefresh: time.Minute,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (j *JWKS) read() ([]byte, error) {
+	if strings.HasPrefix(j.source, "http://") || strings.HasPrefix(j.source, "https://") {
+		resp, err := j.client.Get(j.source)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetch jwks: %s", resp.Status)
+		}
+		return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	}
+	return os.ReadFile(j.source)
+}
+
+// Refresh loads the keys now, the cached keys are kept when it fails
+func (j *JWKS) Refresh() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err := j.refresh()
+	return err
+}
+
+// refresh loads the keys, j.mu must be held
+func (j *JWKS) refresh() (*keySet, error) {
+	j.checked.Store(time.Now().UnixNano())
+	set, err := j.fetch()
+	if err != nil {
+		j.lastErr = err
+		return j.current.Load(), err
+	}
+	j.lastErr = nil
+	j.current.Store(set)
+	return set, nil
+}
+
+func (j *JWKS) fetch() (*keySet, error) {
+	data, err := j.read()
+	if err != nil {
+		return nil, err
+	}
+	var doc jwkSet
+	if err = json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	set := &keySet{keys: make(map[string]crypto.PublicKey, len(doc.Keys))}
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			// skip keys we can not use instead of failing the whole set
+			continue
+		}
+		if k.Kid == "" {
+			set.anonymous = append(set.anonymous, pub)
+		} else {
+			set.keys[k.Kid] = pub
+		}
+	}
+	return set, nil
+}
+
+// since returns how long ago the source was last asked
+func (j *JWKS) since() time.Duration {
+	return time.Since(time.Unix(0, j.checked.Load()))
+}
+
+func (j *JWKS) load() (*keySet, error) {
+	if set := j.current.Load(); set != nil {
+		if j.since() >= j.ttl && j.refreshing.CompareAndSwap(false, true) {
+			go func() {
+				defer j.refreshing.Store(false)
+				_ = j.Refresh()
+			}()
+		}
+		return set, nil
+	}
+	// nothing to fall back to, wait for the source
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if set := j.current.Load(); set != nil {
+		return set, nil
+	}
+	if j.since() < j.ttl {
+		// the source failed recently, do not ask it on every request
+		return nil, j.lastErr
+	}
+	return j.refresh()
+}
+
+// Key implements KeyFunc
+func (j *JWKS) Key(alg string, kid string) (crypto.PublicKey, error) {
+	set, err := j.load()
+	if set == nil {
+		return nil, err
+	}
+	if key, ok := set.find(alg, kid); ok {
+		return key, nil
+	}
+	// key rotation: the idp may have published a new key
+	if j.since() < j.minRefresh {
+		return nil, ErrTokenKeyNotFound
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	// another request may have refreshed while we waited
+	if j.since() >= j.minRefresh {
+		set, _ = j.refresh()
+	} else {
+		set = j.current.Load()
+	}
+	if set != nil {
+		if key, ok := set.find(alg, kid); ok {
+			return key, nil
+		}
+	}
+	return nil, ErrTokenKeyNotFound
+}
+
+// staticKey returns a KeyFunc for a single key
+func staticKey(key crypto.PublicKey) KeyFunc {
+	return func(alg string, kid string) (crypto.PublicKey, error) {
+		if !algMatches(alg, key) {
+			return nil, ErrTokenAlgorithm
+		}
+		return key, nil
+	}
+}
+
+// loadPublicKey reads a PEM encoded PKIX public key or certificate
+func loadPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found in " + path)
+	}
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
@@ -0,0 +1,103 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func jwksDocument(kid string) string {
+	return `{"keys":[{"kty":"oct","kid":"` + kid + `","k":"` + base64.RawURLEncoding.EncodeToString([]byte("secret")) + `"}]}`
+}
+
+func TestJWKSFailureIsNotRetriedPerRequest(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	j := NewJWKS(srv.URL, time.Minute)
+	for i := 0; i < 3; i++ {
+		if _, err := j.Key(HS256, "a"); err == nil {
+			t.Fatal("Key succeeded with a failing source")
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("source asked %d times, want 1", n)
+	}
+}
+
+func TestJWKSRefreshesInBackground(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) > 1 {
+			// refreshes hang until the test is done with the cached keys
+			<-release
+		}
+		_, _ = w.Write([]byte(jwksDocument("a")))
+	}))
+	defer srv.Close()
+	defer close(release)
+	j := NewJWKS(srv.URL, time.Millisecond)
+	if _, err := j.Key(HS256, "a"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := j.Key(HS256, "a"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Key waited %s for the refresh", d)
+	}
+	// the initial load and a single background refresh
+	deadline := time.Now().Add(time.Second)
+	for calls.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("source asked %d times, want 2", n)
+	}
+}
+
+func TestJWKSUnknownKidRefreshesOnce(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kid := "a"
+		if calls.Add(1) > 1 {
+			kid = "b"
+		}
+		_, _ = w.Write([]byte(jwksDocument(kid)))
+	}))
+	defer srv.Close()
+	j := NewJWKS(srv.URL, time.Hour)
+	j.minRefresh = 0
+	if _, err := j.Key(HS256, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Key(HS256, "b"); err != nil {
+		t.Fatalf("rotated key: %v", err)
+	}
+	j.minRefresh = time.Hour
+	if _, err := j.Key(HS256, "c"); err != ErrTokenKeyNotFound {
+		t.Fatalf("err = %v, want ErrTokenKeyNotFound", err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("source asked %d times, want 2", n)
+	}
+}
@@ -0,0 +1,255 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/linxlib/fw_middlewares/auth"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTokenMalformed    = errors.New("token is malformed")
+	ErrTokenSignature    = errors.New("token signature is invalid")
+	ErrTokenAlgorithm    = errors.New("token algorithm is not allowed")
+	ErrTokenKeyNotFound  = errors.New("token signing key not found")
+	ErrTokenExpired      = errors.New("token is expired")
+	ErrTokenNotValidYet  = errors.New("token is not valid yet")
+	ErrTokenIssuer       = errors.New("token issuer is invalid")
+	ErrTokenAudience     = errors.New("token audience is invalid")
+	ErrTokenMissingClaim = errors.New("token is missing exp claim")
+)
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// Claims holds the claims of a validated token
+type Claims map[string]any
+
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+func (c Claims) Issuer() string {
+	return c.String("iss")
+}
+
+// Audience returns aud, which may be a single string or a list in the token
+func (c Claims) Audience() []string {
+	switch v := c["aud"].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		aud := make([]string, 0, len(v))
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				aud = append(aud, s)
+			}
+		}
+		return aud
+	}
+	return nil
+}
+
+// Strings returns a claim which may be a list or a space separated string, e.g. roles or scope
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// Principal maps the claims: sub as ID, name or preferred_username as Name,
+// roles, groups and scope as Roles.
+func (c Claims) Principal(method string) *auth.Principal {
+	name := c.String("name")
+	if name == "" {
+		name = c.String("preferred_username")
+	}
+	var roles []string
+	for _, claim := range []string{"roles", "groups", "scope"} {
+		roles = append(roles, c.Strings(claim)...)
+	}
+	return &auth.Principal{
+		ID:         c.Subject(),
+		Name:       name,
+		Roles:      roles,
+		Method:     method,
+		Attributes: c,
+	}
+}
+
+// Time returns a NumericDate claim such as exp, nbf or iat
+func (c Claims) Time(name string) (time.Time, bool) {
+	switch v := c[name].(type) {
+	case float64:
+		return time.Unix(int64(v), 0), true
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(n, 0), true
+	}
+	return time.Time{}, false
+}
+
+// KeyFunc returns the verification key for the given alg and kid
+type KeyFunc func(alg string, kid string) (crypto.PublicKey, error)
+
+// Validator checks signature and registered claims of a token
+type Validator struct {
+	Algorithms []string
+	Issuer     string
+	Audience   string
+	Leeway     time.Duration
+	// RequireExp rejects tokens without exp
+	RequireExp bool
+	Key        KeyFunc
+}
+
+// Parse verifies the compact serialized token and returns its claims
+func (v *Validator) Parse(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	var h header
+	if err = json.Unmarshal(hb, &h); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if !slices.Contains(v.Algorithms, h.Alg) {
+		return nil, ErrTokenAlgorithm
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	key, err := v.Key(h.Alg, h.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err = verifySignature(h.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+	pb, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	claims := make(Claims)
+	if err = json.Unmarshal(pb, &claims); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if err = v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *Validator) validateClaims(claims Claims) error {
+	now := time.Now()
+	if exp, ok := claims.Time("exp"); ok {
+		if !now.Before(exp.Add(v.Leeway)) {
+			return ErrTokenExpired
+		}
+	} else if v.RequireExp {
+		return ErrTokenMissingClaim
+	}
+	if nbf, ok := claims.Time("nbf"); ok && now.Add(v.Leeway).Before(nbf) {
+		return ErrTokenNotValidYet
+	}
+	if v.Issuer != "" && claims.Issuer() != v.Issuer {
+		return ErrTokenIssuer
+	}
+	if v.Audience != "" && !slices.Contains(claims.Audience(), v.Audience) {
+		return ErrTokenAudience
+	}
+	return nil
+}
+
+// verifySignature checks sig over signingInput, the key type must match the alg
+// so a public key can never be used as a HMAC secret.
+func verifySignature(alg string, key crypto.PublicKey, signingInput string, sig []byte) error {
+	sum := sha256.Sum256([]byte(signingInput))
+	switch alg {
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return ErrTokenAlgorithm
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return ErrTokenSignature
+		}
+	case RS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrTokenAlgorithm
+		}
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) != nil {
+			return ErrTokenSignature
+		}
+	case ES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrTokenAlgorithm
+		}
+		if len(sig) != 64 {
+			return ErrTokenSignature
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, sum[:], r, s) {
+			return ErrTokenSignature
+		}
+	case EdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return ErrTokenAlgorithm
+		}
+		if !ed25519.Verify(pub, []byte(signingInput), sig) {
+			return ErrTokenSignature
+		}
+	default:
+		return ErrTokenAlgorithm
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/linxlib/conv"
 	"github.com/linxlib/fw"
 	"github.com/linxlib/fw/types"
+	"github.com/linxlib/fw_middlewares/auth"
 	"github.com/sirupsen/logrus"
 	"net/http"
 	"time"
@@ -33,6 +34,8 @@ type LogParams struct {
 
 	Protocol  string
 	UserAgent string
+	// Principal is the ID of the authenticated auth.Principal, empty for anonymous requests
+	Principal string
 }
 
 func (p *LogParams) TimeStampWithColor(f string) (string, color.Color) {
@@ -130,6 +133,9 @@ func (w *LogMiddleware) Execute(ctx *fw.MiddlewareContext) fw.HandlerFunc {
 		if exist && err != nil {
 			params.ErrorMessage = "\nErr:" + err.(error).Error()
 		}
+		if p := auth.FromContext(context); p != nil {
+			params.Principal = p.Method + ":" + p.ID
+		}
 
 		info := make([]types.Arg, 0)
 		k, v := params.TimeStampWithColor("%20s")
@@ -166,6 +172,12 @@ func (w *LogMiddleware) Execute(ctx *fw.MiddlewareContext) fw.HandlerFunc {
 			Key:   byteCountSI(int64(params.BodySize)),
 			Value: color.White,
 		})
+		if params.Principal != "" {
+			info = append(info, types.Arg{
+				Key:   params.Principal,
+				Value: color.HiCyan,
+			})
+		}
 		if params.ErrorMessage != "" {
 			info = append(info, types.Arg{
 				Key:   "\n",
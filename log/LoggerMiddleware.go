@@ -5,6 +5,7 @@ import (
 	"github.com/linxlib/conv"
 	"github.com/linxlib/fw"
 	"github.com/linxlib/fw/types"
+	"github.com/linxlib/fw_middlewares/auth"
 	"time"
 )
 
@@ -47,6 +48,9 @@ func (w *LoggerMiddleware) Execute(ctx *fw.MiddlewareContext) fw.HandlerFunc {
 		if exist && err != nil {
 			params.ErrorMessage = "\nErr:" + err.(error).Error()
 		}
+		if p := auth.FromContext(context); p != nil {
+			params.Principal = p.Method + ":" + p.ID
+		}
 		info := make([]types.Arg, 0)
 		k, v := params.TimeStampWithColor("%19s")
 		info = append(info, types.Arg{
@@ -96,6 +100,12 @@ func (w *LoggerMiddleware) Execute(ctx *fw.MiddlewareContext) fw.HandlerFunc {
 			Key:   params.UserAgent,
 			Value: color.Blue,
 		})
+		if params.Principal != "" {
+			info = append(info, types.Arg{
+				Key:   params.Principal,
+				Value: color.HiCyan,
+			})
+		}
 
 		if params.ErrorMessage != "" {
 			info = append(info, types.Arg{
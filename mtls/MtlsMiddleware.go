@@ -0,0 +1,284 @@
+package mtls
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"github.com/linxlib/conv"
+	"github.com/linxlib/fw"
+	"github.com/linxlib/fw_middlewares/auth"
+	"github.com/linxlib/fw_middlewares/basic_auth"
+	"github.com/valyala/fasthttp"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+var _ fw.IMiddlewareCtl = (*MtlsMiddleware)(nil)
+
+const (
+	mtlsAttr = "MTLS"
+	mtlsName = "MTLS"
+
+	// ClientCertKey is the context key of the verified *x509.Certificate
+	ClientCertKey = "client_cert"
+
+	PrincipalCN     = "cn"
+	PrincipalDNS    = "dns"
+	PrincipalSpiffe = "spiffe"
+	PrincipalEmail  = "email"
+)
+
+type MtlsOption struct {
+	CABundle       string   `yaml:"ca_bundle" default:""`                     // PEM file with the CAs client certificates must chain to
+	Principal      string   `yaml:"principal" default:"cn"`                   // cn, dns, spiffe or email, mapped to basic_auth.AuthUserKey
+	ProxyHeader    string   `yaml:"proxy_header" default:"X-SSL-Client-Cert"` // url escaped PEM set by the TLS terminating proxy, e.g. nginx $ssl_client_escaped_cert
+	TrustedProxies []string `yaml:"trusted_proxies"`                          // CIDRs allowed to set proxy_header, the header is ignored when empty
+	AllowedSpiffe  []string `yaml:"allowed_spiffe"`                           // allowed SPIFFE IDs, a trailing * matches a path prefix
+	AllowedDNS     []string `yaml:"allowed_dns"`                              // allowed DNS SANs, *.example.com matches one label
+}
+
+// MtlsMiddleware authenticates clients by TLS client certificate.
+// can be used on Controller, params override the allow lists.
+// the roles of the principal are the OUs of the certificate subject, so ca_bundle must only hold CAs
+// trusted to assign them, e.g. a private CA. a public CA lets any of its customers pick their roles.
+//
+//	// @MTLS allowed_spiffe=spiffe://example.org/ns/prod/* allowed_dns=billing.internal
+type MtlsMiddleware struct {
+	*fw.MiddlewareCtl
+	options *MtlsOption
+	roots   *x509.CertPool
+	proxies []*net.IPNet
+}
+
+func (m *MtlsMiddleware) DoInitOnce() {
+	m.LoadConfig("mtls", m.options)
+	if m.options.CABundle == "" {
+		panic("mtls: ca_bundle is required")
+	}
+	pemData, err := os.ReadFile(m.options.CABundle)
+	if err != nil {
+		panic("mtls: " + err.Error())
+	}
+	m.roots = x509.NewCertPool()
+	if !m.roots.AppendCertsFromPEM(pemData) {
+		panic("mtls: no certificates found in " + m.options.CABundle)
+	}
+	for _, cidr := range m.options.TrustedProxies {
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic("mtls: invalid trusted proxy " + cidr)
+		}
+		m.proxies = append(m.proxies, ipNet)
+	}
+}
+
+func (m *MtlsMiddleware) trustedProxy(ip net.IP) bool {
+	for _, ipNet := range m.proxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerCertificates returns the client chain from the TLS connection,
+// or from the proxy header when the request comes from a trusted proxy.
+func (m *MtlsMiddleware) peerCertificates(fctx *fasthttp.RequestCtx) ([]*x509.Certificate, error) {
+	if state := fctx.TLSConnectionState(); state != nil && len(state.PeerCertificates) > 0 {
+		return state.PeerCertificates, nil
+	}
+	if m.options.ProxyHeader == "" || !m.trustedProxy(fctx.RemoteIP()) {
+		return nil, errors.New("client certificate required")
+	}
+	escaped := conv.String(fctx.Request.Header.Peek(m.options.ProxyHeader))
+	if escaped == "" {
+		return nil, errors.New("client certificate required")
+	}
+	raw, err := url.PathUnescape(escaped)
+	if err != nil {
+		return nil, errors.New("malformed client certificate header")
+	}
+	var certs []*x509.Certificate
+	rest := []byte(raw)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.New("malformed client certificate")
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("malformed client certificate header")
+	}
+	return certs, nil
+}
+
+func (m *MtlsMiddleware) verify(certs []*x509.Certificate) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         m.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err
+}
+
+func spiffeIDs(cert *x509.Certificate) []string {
+	var ids []string
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" {
+			ids = append(ids, u.String())
+		}
+	}
+	return ids
+}
+
+func matchSpiffe(pattern, id string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(id, prefix)
+	}
+	return pattern == id
+}
+
+func matchDNS(pattern, name string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		label, rest, found := strings.Cut(name, ".")
+		return found && label != "" && rest == suffix
+	}
+	return pattern == name
+}
+
+// allowed checks the allow lists, a certificate passes when any list matches.
+// with no list configured every certificate of the CA bundle is accepted.
+func allowed(cert *x509.Certificate, spiffe []string, dns []string) bool {
+	if len(spiffe) == 0 && len(dns) == 0 {
+		return true
+	}
+	for _, id := range spiffeIDs(cert) {
+		for _, p := range spiffe {
+			if matchSpiffe(p, id) {
+				return true
+			}
+		}
+	}
+	for _, name := range cert.DNSNames {
+		for _, p := range dns {
+			if matchDNS(p, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func principal(cert *x509.Certificate, kind string) string {
+	switch kind {
+	case PrincipalDNS:
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	case PrincipalSpiffe:
+		if ids := spiffeIDs(cert); len(ids) > 0 {
+			return ids[0]
+		}
+	case PrincipalEmail:
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	default:
+		return cert.Subject.CommonName
+	}
+	return ""
+}
+
+func splitParam(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (m *MtlsMiddleware) Execute(ctx *fw.MiddlewareContext) fw.HandlerFunc {
+	spiffe := m.options.AllowedSpiffe
+	if v := ctx.GetParam("allowed_spiffe"); v != "" {
+		spiffe = splitParam(v)
+	}
+	dns := m.options.AllowedDNS
+	if v := ctx.GetParam("allowed_dns"); v != "" {
+		dns = splitParam(v)
+	}
+	kind := m.options.Principal
+	if v := ctx.GetParam("principal"); v != "" {
+		kind = v
+	}
+	return func(context *fw.Context) {
+		certs, err := m.peerCertificates(context.GetFastContext())
+		if err != nil {
+			context.JSON(http.StatusUnauthorized, fw.H{"error": err.Error()})
+			return
+		}
+		if err = m.verify(certs); err != nil {
+			context.JSON(http.StatusUnauthorized, fw.H{"error": "client certificate not trusted"})
+			return
+		}
+		cert := certs[0]
+		if !allowed(cert, spiffe, dns) {
+			context.JSON(http.StatusForbidden, fw.H{"error": "client certificate not allowed"})
+			return
+		}
+		user := principal(cert, kind)
+		if user == "" {
+			context.JSON(http.StatusForbidden, fw.H{"error": "client certificate has no " + kind})
+			return
+		}
+		context.Set(basic_auth.AuthUserKey, user)
+		context.Set(ClientCertKey, cert)
+		context.Map(cert)
+		auth.SetPrincipal(context, &auth.Principal{
+			ID:     user,
+			Name:   cert.Subject.CommonName,
+			Roles:  cert.Subject.OrganizationalUnit, // as trusted as the CA which issued the certificate
+			Method: auth.MethodMTLS,
+			Attributes: map[string]any{
+				"serial":    cert.SerialNumber.String(),
+				"issuer":    cert.Issuer.String(),
+				"dns_names": cert.DNSNames,
+				"spiffe":    spiffeIDs(cert),
+			},
+		})
+		ctx.Next(context)
+	}
+}
+
+func NewMtlsMiddleware() fw.IMiddlewareCtl {
+	return &MtlsMiddleware{
+		MiddlewareCtl: fw.NewMiddlewareCtl(mtlsName, mtlsAttr),
+		options:       new(MtlsOption),
+	}
+}
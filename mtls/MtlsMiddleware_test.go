@@ -0,0 +1,118 @@
+package mtls
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+)
+
+func certWith(cn string, dns []string, uris ...string) *x509.Certificate {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}, DNSNames: dns}
+	for _, s := range uris {
+		u, err := url.Parse(s)
+		if err != nil {
+			panic(err)
+		}
+		cert.URIs = append(cert.URIs, u)
+	}
+	return cert
+}
+
+func TestMatchDNS(t *testing.T) {
+	for _, tt := range []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"billing.internal", "billing.internal", true},
+		{"billing.internal", "BILLING.internal.", true},
+		{"billing.internal", "billing.internal.evil", false},
+		{"*.example.com", "api.example.com", true},
+		{"*.example.com", "API.Example.com", true},
+		{"*.example.com.", "api.example.com", true},
+		// the wildcard is a single label
+		{"*.example.com", "a.b.example.com", false},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", ".example.com", false},
+		{"*.example.com", "apiexample.com", false},
+		{"*.example.com", "api.example.com.evil", false},
+		// only a leading wildcard label is one
+		{"api.*.com", "api.example.com", false},
+	} {
+		if got := matchDNS(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchDNS(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchSpiffe(t *testing.T) {
+	for _, tt := range []struct {
+		pattern string
+		id      string
+		want    bool
+	}{
+		{"spiffe://example.org/ns/prod/sa/billing", "spiffe://example.org/ns/prod/sa/billing", true},
+		{"spiffe://example.org/ns/prod/sa/billing", "spiffe://example.org/ns/prod/sa/billing2", false},
+		{"spiffe://example.org/ns/prod/*", "spiffe://example.org/ns/prod/sa/billing", true},
+		{"spiffe://example.org/ns/prod/*", "spiffe://example.org/ns/production/sa/billing", false},
+		{"spiffe://example.org/ns/prod/*", "spiffe://example.org/ns/dev/sa/billing", false},
+		{"spiffe://example.org/ns/prod/*", "spiffe://evil.org/ns/prod/sa/billing", false},
+		{"spiffe://example.org/*", "spiffe://example.org.evil/ns", false},
+	} {
+		if got := matchSpiffe(tt.pattern, tt.id); got != tt.want {
+			t.Errorf("matchSpiffe(%q, %q) = %v, want %v", tt.pattern, tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	billing := certWith("billing", []string{"billing.internal"}, "spiffe://example.org/ns/prod/sa/billing")
+	web := certWith("web", []string{"web.example.com"}, "https://example.com/web")
+	for _, tt := range []struct {
+		name   string
+		cert   *x509.Certificate
+		spiffe []string
+		dns    []string
+		want   bool
+	}{
+		{"no lists accept the CA", web, nil, nil, true},
+		{"spiffe match", billing, []string{"spiffe://example.org/ns/prod/*"}, nil, true},
+		{"spiffe mismatch", billing, []string{"spiffe://example.org/ns/dev/*"}, nil, false},
+		{"dns match", web, nil, []string{"*.example.com"}, true},
+		{"dns mismatch", web, nil, []string{"*.internal"}, false},
+		{"either list", web, []string{"spiffe://example.org/*"}, []string{"web.example.com"}, true},
+		{"other scheme is no spiffe id", web, []string{"https://example.com/*"}, nil, false},
+		{"spiffe list does not match dns", billing, []string{"billing.internal"}, nil, false},
+	} {
+		if got := allowed(tt.cert, tt.spiffe, tt.dns); got != tt.want {
+			t.Errorf("%s: allowed = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPrincipal(t *testing.T) {
+	full := certWith("billing", []string{"billing.internal", "billing.svc"},
+		"https://example.com/billing", "spiffe://example.org/ns/prod/sa/billing")
+	full.EmailAddresses = []string{"billing@example.org"}
+	bare := certWith("bare", nil)
+	for _, tt := range []struct {
+		cert *x509.Certificate
+		kind string
+		want string
+	}{
+		{full, PrincipalCN, "billing"},
+		{full, "", "billing"},
+		{full, PrincipalDNS, "billing.internal"},
+		{full, PrincipalSpiffe, "spiffe://example.org/ns/prod/sa/billing"},
+		{full, PrincipalEmail, "billing@example.org"},
+		// no fallback to the cn, Execute answers 403
+		{bare, PrincipalDNS, ""},
+		{bare, PrincipalSpiffe, ""},
+		{bare, PrincipalEmail, ""},
+	} {
+		if got := principal(tt.cert, tt.kind); got != tt.want {
+			t.Errorf("principal(%s, %q) = %q, want %q", tt.cert.Subject.CommonName, tt.kind, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package oidc
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/linxlib/conv"
+	"github.com/linxlib/fw"
+	"github.com/linxlib/fw_middlewares/auth"
+	"github.com/linxlib/fw_middlewares/jwt"
+	"github.com/linxlib/fw_middlewares/session"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var _ fw.IMiddlewareGlobal = (*OidcMiddleware)(nil)
+
+const (
+	oidcName = "Oidc"
+
+	// ClaimsKey is the context key of the logged in user's id token claims
+	ClaimsKey = "oidc_claims"
+
+	sessionUserKey     = "oidc_user"
+	sessionIDTokenKey  = "oidc_id_token"
+	sessionStateKey    = "oidc_state"
+	sessionNonceKey    = "oidc_nonce"
+	sessionVerifierKey = "oidc_verifier"
+	sessionRedirectKey = "oidc_redirect"
+)
+
+type OidcOption struct {
+	Issuer                string   `yaml:"issuer" default:""` // e.g. https://idp.example.com/realms/main
+	ClientID              string   `yaml:"client_id" default:""`
+	ClientSecret          string   `yaml:"client_secret" default:""` // empty for public clients, PKCE is always used
+	RedirectURL           string   `yaml:"redirect_url" default:""`  // absolute url of the callback route
+	Scopes                []string `yaml:"scopes"`                   // openid profile email when empty
+	PostLogoutRedirectURL string   `yaml:"post_logout_redirect_url" default:""`
+	LoginPath             string   `yaml:"login_path" default:"/auth/login"`
+	CallbackPath          string   `yaml:"callback_path" default:"/auth/callback"`
+	LogoutPath            string   `yaml:"logout_path" default:"/auth/logout"`
+	ProtectedPaths        []string `yaml:"protected_paths"` // path prefixes redirected to login when not logged in
+	TrustedOrigins        []string `yaml:"trusted_origins"` // origins besides the request host allowed to post the logout
+}
+
+// OidcMiddleware does the authorization code + PKCE login against an OpenID Connect provider.
+// The user is kept in the session, so SessionMiddleware must be registered before it.
+// Claims of the logged in user are mapped as jwt.Claims and set under ClaimsKey.
+type OidcMiddleware struct {
+	*fw.MiddlewareGlobal
+	options  *OidcOption
+	provider *provider
+}
+
+func (o *OidcMiddleware) DoInitOnce() {
+	o.LoadConfig("oidc", o.options)
+	if len(o.options.Scopes) == 0 {
+		o.options.Scopes = []string{"openid", "profile", "email"}
+	}
+	o.provider = newProvider(o.options)
+}
+
+// sessionOf returns the session of the request, or answers 500 when SessionMiddleware is not registered
+func sessionOf(context *fw.Context) *session.Session {
+	sess := session.FromContext(context)
+	if sess == nil {
+		context.JSON(http.StatusInternalServerError, fw.H{"error": "oidc: OidcMiddleware requires SessionMiddleware"})
+	}
+	return sess
+}
+
+// loginSession is the part of session.Session the login flow uses
+type loginSession interface {
+	GetString(key string) string
+	Set(key string, value any)
+	Delete(key string)
+	RenewID()
+}
+
+// loginError is a failed login step, body is answered with status
+type loginError struct {
+	status int
+	body   fw.H
+}
+
+func (e *loginError) Error() string {
+	return fmt.Sprint(e.body["error"])
+}
+
+func loginFailed(status int, msg string) error {
+	return &loginError{status: status, body: fw.H{"error": msg}}
+}
+
+func claimsOf(sess *session.Session) jwt.Claims {
+	v, ok := sess.Get(sessionUserKey)
+	if !ok {
+		return nil
+	}
+	switch claims := v.(type) {
+	case jwt.Claims:
+		return claims
+	case map[string]any:
+		return claims
+	}
+	return nil
+}
+
+func (o *OidcMiddleware) protected(path string) bool {
+	for _, prefix := range o.options.ProtectedPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *OidcMiddleware) Execute(ctx *fw.MiddlewareContext) fw.HandlerFunc {
+	return func(context *fw.Context) {
+		sess := sessionOf(context)
+		if sess == nil {
+			return
+		}
+		if claims := claimsOf(sess); claims != nil {
+			context.Set(ClaimsKey, claims)
+			context.Map(claims)
+			auth.SetPrincipal(context, claims.Principal(auth.MethodOIDC))
+		} else if path := conv.String(context.GetFastContext().Path()); o.protected(path) {
+			target := conv.String(context.GetFastContext().RequestURI())
+			context.GetFastContext().Redirect(o.options.LoginPath+"?redirect="+url.QueryEscape(target), http.StatusFound)
+			return
+		}
+		ctx.Next(context)
+	}
+}
+
+func (o *OidcMiddleware) Router(ctx *fw.MiddlewareContext) []*fw.RouteItem {
+	return []*fw.RouteItem{
+		{
+			Method:     "GET",
+			Path:       o.options.LoginPath,
+			IsHide:     true,
+			H:          o.login,
+			Middleware: o,
+		},
+		{
+			Method:     "GET",
+			Path:       o.options.CallbackPath,
+			IsHide:     true,
+			H:          o.callback,
+			Middleware: o,
+		},
+		{
+			// a GET could be triggered by any page embedding the url
+			Method:     "POST",
+			Path:       o.options.LogoutPath,
+			IsHide:     true,
+			H:          o.logout,
+			Middleware: o,
+		},
+	}
+}
+
+func (o *OidcMiddleware) login(context *fw.Context) {
+	sess := sessionOf(context)
+	if sess == nil {
+		return
+	}
+	target, err := o.begin(sess, conv.String(context.GetFastContext().QueryArgs().Peek("redirect")))
+	if err != nil {
+		context.JSON(http.StatusBadGateway, fw.H{"error": err.Error()})
+		return
+	}
+	context.GetFastContext().Redirect(target, http.StatusFound)
+}
+
+// begin stores a new login attempt in sess and returns the authorization url of the idp
+func (o *OidcMiddleware) begin(sess loginSession, redirect string) (string, error) {
+	d, _, err := o.provider.discover()
+	if err != nil {
+		return "", err
+	}
+	state := randomString(24)
+	nonce := randomString(24)
+	verifier := randomString(48)
+	sess.Set(sessionStateKey, state)
+	sess.Set(sessionNonceKey, nonce)
+	sess.Set(sessionVerifierKey, verifier)
+	sess.Set(sessionRedirectKey, auth.SafeRedirect(redirect))
+	return o.provider.authURL(d, state, nonce, verifier), nil
+}
+
+func (o *OidcMiddleware) callback(context *fw.Context) {
+	sess := sessionOf(context)
+	if sess == nil {
+		return
+	}
+	redirect, err := o.complete(sess, context.GetFastContext().QueryArgs())
+	if err != nil {
+		e := err.(*loginError)
+		context.JSON(e.status, e.body)
+		return
+	}
+	context.GetFastContext().Redirect(redirect, http.StatusFound)
+}
+
+// complete finishes the login attempt in sess with the callback query of the idp. it logs the user in
+// and returns where to send them, or returns a *loginError.
+func (o *OidcMiddleware) complete(sess loginSession, query *fasthttp.Args) (string, error) {
+	state := sess.GetString(sessionStateKey)
+	nonce := sess.GetString(sessionNonceKey)
+	verifier := sess.GetString(sessionVerifierKey)
+	redirect := auth.SafeRedirect(sess.GetString(sessionRedirectKey))
+	// the login attempt is single use
+	sess.Delete(sessionStateKey)
+	sess.Delete(sessionNonceKey)
+	sess.Delete(sessionVerifierKey)
+	sess.Delete(sessionRedirectKey)
+
+	if e := conv.String(query.Peek("error")); e != "" {
+		return "", &loginError{status: http.StatusUnauthorized, body: fw.H{"error": e, "error_description": conv.String(query.Peek("error_description"))}}
+	}
+	got := conv.String(query.Peek("state"))
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(got)) != 1 {
+		return "", loginFailed(http.StatusBadRequest, "invalid state")
+	}
+	code := conv.String(query.Peek("code"))
+	if code == "" {
+		return "", loginFailed(http.StatusBadRequest, "code missing")
+	}
+	d, validator, err := o.provider.discover()
+	if err != nil {
+		return "", loginFailed(http.StatusBadGateway, err.Error())
+	}
+	token, err := o.provider.exchange(d, code, verifier)
+	if err != nil {
+		return "", loginFailed(http.StatusBadGateway, err.Error())
+	}
+	claims, err := validator.Parse(token.IDToken)
+	if err != nil {
+		return "", loginFailed(http.StatusUnauthorized, "invalid id_token: "+err.Error())
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.String("nonce")), []byte(nonce)) != 1 {
+		return "", loginFailed(http.StatusUnauthorized, "invalid id_token: nonce mismatch")
+	}
+	delete(claims, "nonce")
+	// new id after login, prevents session fixation
+	sess.RenewID()
+	sess.Set(sessionUserKey, map[string]any(claims))
+	sess.Set(sessionIDTokenKey, token.IDToken)
+	return redirect, nil
+}
+
+// logout only accepts POST from our own pages, a cross site form could log the user out
+func (o *OidcMiddleware) logout(context *fw.Context) {
+	if reason, ok := auth.CheckOrigin(context.GetFastContext(), o.options.TrustedOrigins); !ok {
+		context.JSON(http.StatusForbidden, fw.H{"error": reason})
+		return
+	}
+	sess := sessionOf(context)
+	if sess == nil {
+		return
+	}
+	idToken := sess.GetString(sessionIDTokenKey)
+	sess.Destroy()
+	target := "/"
+	if d, _, err := o.provider.discover(); err == nil && d.EndSessionEndpoint != "" {
+		q := url.Values{}
+		if idToken != "" {
+			q.Set("id_token_hint", idToken)
+		}
+		q.Set("client_id", o.options.ClientID)
+		if o.options.PostLogoutRedirectURL != "" {
+			q.Set("post_logout_redirect_uri", o.options.PostLogoutRedirectURL)
+		}
+		target = d.EndSessionEndpoint + "?" + q.Encode()
+	} else if o.options.PostLogoutRedirectURL != "" {
+		target = o.options.PostLogoutRedirectURL
+	}
+	context.GetFastContext().Redirect(target, http.StatusFound)
+}
+
+func NewOidcMiddleware() fw.IMiddlewareGlobal {
+	return &OidcMiddleware{
+		MiddlewareGlobal: fw.NewMiddlewareGlobal(oidcName),
+		options:          new(OidcOption),
+	}
+}
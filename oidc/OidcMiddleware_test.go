@@ -0,0 +1,303 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/valyala/fasthttp"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memorySession is a loginSession without SessionMiddleware
+type memorySession struct {
+	values  map[string]any
+	renewed bool
+}
+
+func (m *memorySession) GetString(key string) string {
+	s, _ := m.values[key].(string)
+	return s
+}
+
+func (m *memorySession) Set(key string, value any) { m.values[key] = value }
+
+func (m *memorySession) Delete(key string) { delete(m.values, key) }
+
+func (m *memorySession) RenewID() { m.renewed = true }
+
+// grant is an authorization code issued by the idp
+type grant struct {
+	nonce     string
+	challenge string
+}
+
+// idp is a local OpenID provider signing id tokens with RS256
+type idp struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]grant
+}
+
+func newIdp(t *testing.T) *idp {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &idp{key: key, grants: make(map[string]grant)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(discovery{
+			Issuer:                p.URL,
+			AuthorizationEndpoint: p.URL + "/auth",
+			TokenEndpoint:         p.URL + "/token",
+			JwksURI:               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "idp",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/auth", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// authorize logs the user in right away and redirects back with a code
+func (p *idp) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != "app" || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	code := randomString(16)
+	p.mu.Lock()
+	p.grants[code] = grant{nonce: q.Get("nonce"), challenge: q.Get("code_challenge")}
+	p.mu.Unlock()
+	back := url.Values{"code": {code}, "state": {q.Get("state")}}
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+back.Encode(), http.StatusFound)
+}
+
+func (p *idp) token(w http.ResponseWriter, r *http.Request) {
+	tokenError := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(tokenResponse{Error: code})
+	}
+	if id, secret, _ := r.BasicAuth(); id != "app" || secret != "app-secret" {
+		tokenError("invalid_client")
+		return
+	}
+	code := r.PostFormValue("code")
+	p.mu.Lock()
+	g, ok := p.grants[code]
+	delete(p.grants, code)
+	p.mu.Unlock()
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" {
+		tokenError("invalid_grant")
+		return
+	}
+	if codeChallenge(r.PostFormValue("code_verifier")) != g.challenge {
+		tokenError("invalid_grant")
+		return
+	}
+	_ = json.NewEncoder(w).Encode(tokenResponse{IDToken: p.sign(map[string]any{
+		"iss":   p.URL,
+		"aud":   "app",
+		"sub":   "alice",
+		"nonce": g.nonce,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}), TokenType: "Bearer"})
+}
+
+func (p *idp) sign(claims map[string]any) string {
+	h, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "idp", "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	sum := sha256.Sum256([]byte(input))
+	p.mu.Lock()
+	key := p.key
+	p.mu.Unlock()
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		panic(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newTestOidc(p *idp) *OidcMiddleware {
+	options := &OidcOption{
+		Issuer:       p.URL,
+		ClientID:     "app",
+		ClientSecret: "app-secret",
+		RedirectURL:  "http://app.example.com/auth/callback",
+		Scopes:       []string{"openid"},
+	}
+	return &OidcMiddleware{options: options, provider: newProvider(options)}
+}
+
+// visit follows the authorization url like a browser and returns the callback query
+func visit(t *testing.T, authURL string) *fasthttp.Args {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("idp answered %s, location %q", resp.Status, resp.Header.Get("Location"))
+	}
+	if location.Host != "app.example.com" || location.Path != "/auth/callback" {
+		t.Fatalf("redirected to %s", location)
+	}
+	query := &fasthttp.Args{}
+	query.Parse(location.RawQuery)
+	return query
+}
+
+func status(err error) int {
+	var e *loginError
+	if errors.As(err, &e) {
+		return e.status
+	}
+	return 0
+}
+
+func TestLoginFlow(t *testing.T) {
+	p := newIdp(t)
+	o := newTestOidc(p)
+	sess := &memorySession{values: map[string]any{}}
+	authURL, err := o.begin(sess, "/reports?y=2024")
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect, err := o.complete(sess, visit(t, authURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redirect != "/reports?y=2024" {
+		t.Errorf("redirect = %q", redirect)
+	}
+	claims, _ := sess.values[sessionUserKey].(map[string]any)
+	if claims["sub"] != "alice" {
+		t.Fatalf("logged in as %v", claims)
+	}
+	if _, ok := claims["nonce"]; ok {
+		t.Error("nonce kept in the session claims")
+	}
+	if sess.GetString(sessionIDTokenKey) == "" {
+		t.Error("id token not kept for the logout")
+	}
+	if !sess.renewed {
+		t.Error("session id not renewed after login")
+	}
+	for _, key := range []string{sessionStateKey, sessionNonceKey, sessionVerifierKey, sessionRedirectKey} {
+		if _, ok := sess.values[key]; ok {
+			t.Errorf("%s kept after the callback", key)
+		}
+	}
+}
+
+func TestLoginRejectsState(t *testing.T) {
+	p := newIdp(t)
+	o := newTestOidc(p)
+	sess := &memorySession{values: map[string]any{}}
+	authURL, err := o.begin(sess, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := visit(t, authURL)
+	forged := &fasthttp.Args{}
+	query.CopyTo(forged)
+	forged.Set("state", "forged")
+	if _, err = o.complete(sess, forged); status(err) != http.StatusBadRequest {
+		t.Fatalf("forged state: %v", err)
+	}
+	// the attempt is single use, the genuine callback can not follow the forged one
+	if _, err = o.complete(sess, query); status(err) != http.StatusBadRequest {
+		t.Fatalf("replayed state: %v", err)
+	}
+	if _, ok := sess.values[sessionUserKey]; ok {
+		t.Fatal("logged in with a rejected state")
+	}
+}
+
+func TestLoginRejectsVerifier(t *testing.T) {
+	p := newIdp(t)
+	o := newTestOidc(p)
+	sess := &memorySession{values: map[string]any{}}
+	authURL, err := o.begin(sess, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := visit(t, authURL)
+	// a stolen code is redeemed with the verifier of another login
+	sess.Set(sessionVerifierKey, randomString(48))
+	if _, err = o.complete(sess, query); status(err) != http.StatusBadGateway {
+		t.Fatalf("wrong verifier: %v", err)
+	}
+	if _, ok := sess.values[sessionUserKey]; ok {
+		t.Fatal("logged in with a wrong verifier")
+	}
+}
+
+func TestLoginRejectsNonce(t *testing.T) {
+	p := newIdp(t)
+	o := newTestOidc(p)
+	sess := &memorySession{values: map[string]any{}}
+	authURL, err := o.begin(sess, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := visit(t, authURL)
+	// the id token was issued for another login attempt
+	sess.Set(sessionNonceKey, randomString(24))
+	if _, err = o.complete(sess, query); status(err) != http.StatusUnauthorized {
+		t.Fatalf("wrong nonce: %v", err)
+	}
+	if _, ok := sess.values[sessionUserKey]; ok || sess.renewed {
+		t.Fatal("logged in with a wrong nonce")
+	}
+}
+
+func TestLoginRejectsForeignSignature(t *testing.T) {
+	p := newIdp(t)
+	o := newTestOidc(p)
+	sess := &memorySession{values: map[string]any{}}
+	authURL, err := o.begin(sess, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := visit(t, authURL)
+	// the token endpoint now signs with a key missing from the jwks
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	p.key = other
+	p.mu.Unlock()
+	if _, err = o.complete(sess, query); status(err) != http.StatusUnauthorized {
+		t.Fatalf("foreign signature: %v", err)
+	}
+}
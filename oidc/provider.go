@@ -0,0 +1,199 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/linxlib/fw_middlewares/jwt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// discovery is the subset of the openid-configuration document we use
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// discoveryRetry is how long a failed discovery is returned before the idp is asked again
+const discoveryRetry = 30 * time.Second
+
+// provider lazily discovers the idp configuration and caches it
+type provider struct {
+	options *OidcOption
+	client  *http.Client
+	retry   time.Duration
+
+	mu        sync.Mutex
+	discovery *discovery
+	validator *jwt.Validator
+	lastErr   error
+	failedAt  time.Time
+	fetching  chan struct{} // closed when the running discovery is done, nil when none runs
+}
+
+func newProvider(options *OidcOption) *provider {
+	return &provider{
+		options: options,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		retry:   discoveryRetry,
+	}
+}
+
+// discover returns the cached configuration. the idp is asked outside the lock by one request at a time,
+// the others wait for its result, and a failure is returned for retry before it is asked again.
+func (p *provider) discover() (*discovery, *jwt.Validator, error) {
+	p.mu.Lock()
+	for p.fetching != nil {
+		fetching := p.fetching
+		p.mu.Unlock()
+		<-fetching
+		p.mu.Lock()
+	}
+	if d, validator := p.discovery, p.validator; d != nil {
+		p.mu.Unlock()
+		return d, validator, nil
+	}
+	if err := p.lastErr; err != nil && time.Since(p.failedAt) < p.retry {
+		p.mu.Unlock()
+		return nil, nil, err
+	}
+	fetching := make(chan struct{})
+	p.fetching = fetching
+	p.mu.Unlock()
+
+	d, validator, err := p.fetch()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fetching = nil
+	close(fetching)
+	if err != nil {
+		p.lastErr = err
+		p.failedAt = time.Now()
+		return nil, nil, err
+	}
+	p.lastErr = nil
+	p.discovery = d
+	p.validator = validator
+	return d, validator, nil
+}
+
+func (p *provider) fetch() (*discovery, *jwt.Validator, error) {
+	wellKnown := strings.TrimSuffix(p.options.Issuer, "/") + "/.well-known/openid-configuration"
+	resp, err := p.client.Get(wellKnown)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("oidc discovery: %s", resp.Status)
+	}
+	d := new(discovery)
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(d); err != nil {
+		return nil, nil, err
+	}
+	if d.Issuer != p.options.Issuer {
+		return nil, nil, fmt.Errorf("oidc discovery: issuer %q does not match %q", d.Issuer, p.options.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JwksURI == "" {
+		return nil, nil, errors.New("oidc discovery: missing endpoints")
+	}
+	validator := &jwt.Validator{
+		Algorithms: []string{jwt.RS256, jwt.ES256, jwt.EdDSA},
+		Issuer:     d.Issuer,
+		Audience:   p.options.ClientID,
+		Leeway:     time.Minute,
+		RequireExp: true,
+		Key:        jwt.NewJWKS(d.JwksURI, time.Hour).Key,
+	}
+	return d, validator, nil
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// codeChallenge is the PKCE S256 challenge of verifier
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (p *provider) authURL(d *discovery, state, nonce, verifier string) string {
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.options.ClientID)
+	q.Set("redirect_uri", p.options.RedirectURL)
+	q.Set("scope", strings.Join(p.options.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", codeChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode()
+}
+
+// exchange redeems the authorization code at the token endpoint
+func (p *provider) exchange(d *discovery, code, verifier string) (*tokenResponse, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.options.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.options.ClientID)
+	req, err := http.NewRequest(http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.options.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.options.ClientID), url.QueryEscape(p.options.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	token := new(tokenResponse)
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(token); err != nil {
+		return nil, fmt.Errorf("oidc token: %s", resp.Status)
+	}
+	if token.Error != "" {
+		return nil, fmt.Errorf("oidc token: %s %s", token.Error, token.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc token: %s", resp.Status)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("oidc token: id_token missing")
+	}
+	return token, nil
+}
@@ -0,0 +1,61 @@
+package oidc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDiscoverCachesFailures(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	p := newProvider(&OidcOption{Issuer: srv.URL})
+	for i := 0; i < 3; i++ {
+		if _, _, err := p.discover(); err == nil {
+			t.Fatal("discover succeeded against a failing idp")
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("idp asked %d times, want 1", n)
+	}
+	p.retry = 0
+	_, _, _ = p.discover()
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("idp asked %d times after the retry delay, want 2", n)
+	}
+}
+
+func TestDiscoverIsSingleFlight(t *testing.T) {
+	var calls atomic.Int32
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"issuer":"` + srv.URL + `","authorization_endpoint":"` + srv.URL + `/auth",` +
+			`"token_endpoint":"` + srv.URL + `/token","jwks_uri":"` + srv.URL + `/jwks"}`))
+	}))
+	defer srv.Close()
+	p := newProvider(&OidcOption{Issuer: srv.URL})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if d, v, err := p.discover(); err != nil || d == nil || v == nil {
+				t.Errorf("discover: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("idp asked %d times, want 1", n)
+	}
+}
@@ -0,0 +1,387 @@
+package proxy
+
+import (
+	"github.com/linxlib/conv"
+	"github.com/linxlib/fw"
+	"github.com/linxlib/fw_middlewares/auth"
+	"github.com/linxlib/fw_middlewares/basic_auth"
+	"github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var _ fw.IMiddlewareGlobal = (*ProxyMiddleware)(nil)
+
+const proxyName = "ForwardProxy"
+
+// realm of the Proxy-Authenticate challenge
+const proxyRealm = `Basic realm="Proxy Authorization Required"`
+
+type ProxyOption struct {
+	// proxy user => allowed destinations, "*" as user applies to every user.
+	// a destination is host, host:port, *.example.com or * for anywhere
+	Allow map[string][]string `yaml:"allow"`
+	// proxy user => password, checked against Proxy-Authorization unless a global middleware
+	// before the proxy already set a principal
+	Users       map[string]string `yaml:"users"`
+	DialTimeout string            `yaml:"dial_timeout" default:"10s"`
+	IdleTimeout string            `yaml:"idle_timeout" default:"5m"` // CONNECT tunnels idle longer than this are closed
+	// registers CONNECT and ANY catch-all routes instead of wrapping the server with Handler.
+	// paths which are no route of the app then get the 404 of the proxy, and absolute URIs whose
+	// path is a route of the app still reach the app
+	CatchAll bool `yaml:"catch_all" default:"false"`
+}
+
+// ProxyMiddleware is a forward HTTP proxy for absolute-URI requests and CONNECT tunnels.
+// Handler takes them before the app routes anything, register the middleware as well so it
+// loads the forwardProxy section. requests routed to the app are never proxied.
+// Proxy-Authorization is checked against forwardProxy.users and the credential provider, if any.
+//
+//	p := proxy.NewProxyMiddleware(logger)
+//	server := &fasthttp.Server{Handler: p.Handler(appHandler)}
+type ProxyMiddleware struct {
+	*fw.MiddlewareGlobal
+	Logger      *logrus.Logger `inject:""`
+	options     *ProxyOption
+	provider    basic_auth.CredentialProvider
+	checker     *basic_auth.Checker // nil when neither users nor a provider are configured
+	client      *fasthttp.Client
+	dialTimeout time.Duration
+	idleTimeout time.Duration
+}
+
+func (p *ProxyMiddleware) DoInitOnce() {
+	p.LoadConfig("forwardProxy", p.options)
+	var err error
+	if p.dialTimeout, err = time.ParseDuration(p.options.DialTimeout); err != nil {
+		panic("forward proxy: invalid dial_timeout: " + err.Error())
+	}
+	if p.idleTimeout, err = time.ParseDuration(p.options.IdleTimeout); err != nil {
+		panic("forward proxy: invalid idle_timeout: " + err.Error())
+	}
+	if len(p.options.Users) > 0 || p.provider != nil {
+		p.checker = basic_auth.NewChecker(p.options.Users, p.provider)
+	}
+	p.client = p.newClient()
+}
+
+func (p *ProxyMiddleware) newClient() *fasthttp.Client {
+	return &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return net.DialTimeout("tcp", addr, p.dialTimeout)
+		},
+		// the request is forwarded as is, do not touch the path
+		DisablePathNormalizing:        true,
+		DisableHeaderNamesNormalizing: true,
+		NoDefaultUserAgentHeader:      true,
+		ReadTimeout:                   p.idleTimeout,
+		WriteTimeout:                  p.idleTimeout,
+	}
+}
+
+// hop-by-hop headers are meant for the proxy only
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders removes hopHeaders and the headers listed in Connection, see RFC 9110 7.6.1
+func removeHopHeaders(h interface {
+	Peek(string) []byte
+	Del(string)
+}) {
+	for _, name := range strings.Split(string(h.Peek("Connection")), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			h.Del(name)
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+func withPort(host string, defaultPort string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), defaultPort)
+}
+
+func matchDestination(pattern string, hostPort string) bool {
+	if pattern == "*" {
+		return true
+	}
+	host, port, _ := net.SplitHostPort(hostPort)
+	host = strings.ToLower(host)
+	pattern = strings.ToLower(pattern)
+	patternHost, patternPort, err := net.SplitHostPort(pattern)
+	if err != nil {
+		patternHost = pattern
+		patternPort = ""
+	}
+	if patternPort != "" && patternPort != port {
+		return false
+	}
+	if suffix, ok := strings.CutPrefix(patternHost, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return patternHost == host
+}
+
+func (p *ProxyMiddleware) allowed(user string, hostPort string) bool {
+	for _, key := range []string{user, "*"} {
+		for _, pattern := range p.options.Allow[key] {
+			if matchDestination(pattern, hostPort) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkUser checks Proxy-Authorization, it returns nil when no user matches
+func (p *ProxyMiddleware) checkUser(fctx *fasthttp.RequestCtx) *auth.Principal {
+	if p.checker == nil {
+		return nil
+	}
+	principal, found := p.checker.Check(conv.String(fctx.Request.Header.Peek("Proxy-Authorization")), auth.MethodProxyBasic)
+	if !found {
+		return nil
+	}
+	return principal
+}
+
+// proxyUser returns the user authenticated by a middleware before the proxy, then checks Proxy-Authorization
+func (p *ProxyMiddleware) proxyUser(context *fw.Context) string {
+	if v, ok := context.Get(basic_auth.AuthProxyUserKey); ok {
+		if user, ok := v.(string); ok {
+			return user
+		}
+	}
+	if principal := auth.FromContext(context); principal != nil && principal.Method == auth.MethodProxyBasic {
+		return principal.ID
+	}
+	principal := p.checkUser(context.GetFastContext())
+	if principal == nil {
+		return ""
+	}
+	context.Set(basic_auth.AuthProxyUserKey, principal.ID)
+	auth.SetPrincipal(context, principal)
+	return principal.ID
+}
+
+// isProxyRequest reports CONNECT requests and requests with an absolute http or https URI
+func isProxyRequest(fctx *fasthttp.RequestCtx) bool {
+	if fctx.IsConnect() {
+		return true
+	}
+	uri := conv.String(fctx.Request.Header.RequestURI())
+	return hasPrefixFold(uri, "http://") || hasPrefixFold(uri, "https://")
+}
+
+func hasPrefixFold(s string, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+func (p *ProxyMiddleware) logTransfer(user, method, target string, status int, sent, received int64, start time.Time) {
+	if p.Logger == nil {
+		return
+	}
+	p.Logger.WithFields(logrus.Fields{
+		"user":     user,
+		"method":   method,
+		"target":   target,
+		"status":   status,
+		"sent":     sent,
+		"received": received,
+		"duration": time.Since(start).String(),
+	}).Info("[ForwardProxy]")
+}
+
+// proxy tunnels or forwards a proxy request of user, an empty user is asked to authenticate
+func (p *ProxyMiddleware) proxy(fctx *fasthttp.RequestCtx, user string) {
+	if user == "" {
+		fctx.Response.Header.Set("Proxy-Authenticate", proxyRealm)
+		fctx.SetStatusCode(http.StatusProxyAuthRequired)
+		return
+	}
+	if fctx.IsConnect() {
+		p.tunnel(fctx, user)
+		return
+	}
+	p.forward(fctx, user)
+}
+
+// Handler proxies CONNECT and absolute-URI requests before next routes them, every other request
+// goes to next untouched, so the 404 and 405 answers of the app stay its own
+func (p *ProxyMiddleware) Handler(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(fctx *fasthttp.RequestCtx) {
+		if !isProxyRequest(fctx) {
+			next(fctx)
+			return
+		}
+		user := ""
+		if principal := p.checkUser(fctx); principal != nil {
+			user = principal.ID
+		}
+		p.proxy(fctx, user)
+	}
+}
+
+func (p *ProxyMiddleware) Execute(ctx *fw.MiddlewareContext) fw.HandlerFunc {
+	return func(context *fw.Context) {
+		// the app routed the request, it is not proxied even when its URI is absolute
+		ctx.Next(context)
+	}
+}
+
+// Router registers the catch-all routes of forwardProxy.catch_all, the path of a CONNECT
+// request is its authority and absolute URIs carry the path of the destination
+func (p *ProxyMiddleware) Router(ctx *fw.MiddlewareContext) []*fw.RouteItem {
+	if !p.options.CatchAll {
+		return nil
+	}
+	h := func(context *fw.Context) {
+		fctx := context.GetFastContext()
+		if !isProxyRequest(fctx) {
+			context.String(http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+		p.proxy(fctx, p.proxyUser(context))
+	}
+	return []*fw.RouteItem{
+		{
+			Method:     "CONNECT",
+			Path:       "/{all:*}",
+			IsHide:     true,
+			H:          h,
+			Middleware: p,
+		},
+		{
+			Method:     "ANY",
+			Path:       "/{all:*}",
+			IsHide:     true,
+			H:          h,
+			Middleware: p,
+		},
+	}
+}
+
+// forward sends an absolute-URI request upstream and copies the response back
+func (p *ProxyMiddleware) forward(fctx *fasthttp.RequestCtx, user string) {
+	start := time.Now()
+	defaultPort := "80"
+	if conv.String(fctx.URI().Scheme()) == "https" {
+		defaultPort = "443"
+	}
+	target := withPort(conv.String(fctx.URI().Host()), defaultPort)
+	method := conv.String(fctx.Method())
+	if !p.allowed(user, target) {
+		p.logTransfer(user, method, target, http.StatusForbidden, 0, 0, start)
+		fctx.Error("destination not allowed", http.StatusForbidden)
+		return
+	}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	fctx.Request.CopyTo(req)
+	removeHopHeaders(&req.Header)
+	req.SetRequestURI(conv.String(fctx.Request.Header.RequestURI()))
+	resp := &fctx.Response
+	if err := p.client.Do(req, resp); err != nil {
+		p.logTransfer(user, method, target, http.StatusBadGateway, int64(len(req.Body())), 0, start)
+		fctx.Error("bad gateway: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	removeHopHeaders(&resp.Header)
+	p.logTransfer(user, method, target, resp.StatusCode(), int64(len(req.Body())), int64(len(resp.Body())), start)
+}
+
+// idleConn extends the deadline on every read and write so only idle tunnels time out
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	_ = c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(b)
+}
+
+func (c *idleConn) Write(b []byte) (int, error) {
+	_ = c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(b)
+}
+
+// tunnel answers CONNECT with 200 and pipes bytes between client and destination
+func (p *ProxyMiddleware) tunnel(fctx *fasthttp.RequestCtx, user string) {
+	start := time.Now()
+	target := conv.String(fctx.Request.Header.RequestURI())
+	if target == "" || strings.Contains(target, "/") {
+		target = conv.String(fctx.Request.Header.Host())
+	}
+	target = withPort(target, "443")
+	if !p.allowed(user, target) {
+		p.logTransfer(user, http.MethodConnect, target, http.StatusForbidden, 0, 0, start)
+		fctx.Error("destination not allowed", http.StatusForbidden)
+		return
+	}
+	upstream, err := net.DialTimeout("tcp", target, p.dialTimeout)
+	if err != nil {
+		p.logTransfer(user, http.MethodConnect, target, http.StatusBadGateway, 0, 0, start)
+		fctx.Error("bad gateway: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	fctx.SetStatusCode(http.StatusOK)
+	fctx.Hijack(func(client net.Conn) {
+		up := &idleConn{Conn: upstream, timeout: p.idleTimeout}
+		down := &idleConn{Conn: client, timeout: p.idleTimeout}
+		defer up.Close()
+		var sent, received atomic.Int64
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			n, _ := io.Copy(up, down)
+			sent.Add(n)
+			// let the destination know the client is done
+			if tcp, ok := upstream.(*net.TCPConn); ok {
+				_ = tcp.CloseWrite()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			n, _ := io.Copy(down, up)
+			received.Add(n)
+			_ = client.SetDeadline(time.Now())
+		}()
+		wg.Wait()
+		p.logTransfer(user, http.MethodConnect, target, http.StatusOK, sent.Load(), received.Load(), start)
+	})
+}
+
+// NewProxyMiddleware creates the forward proxy configured by the forwardProxy section,
+// pass a provider to verify proxy users not listed in forwardProxy.users
+func NewProxyMiddleware(logger *logrus.Logger, provider ...basic_auth.CredentialProvider) *ProxyMiddleware {
+	p := &ProxyMiddleware{
+		MiddlewareGlobal: fw.NewMiddlewareGlobal(proxyName),
+		Logger:           logger,
+		options:          new(ProxyOption),
+	}
+	if len(provider) > 0 {
+		p.provider = provider[0]
+	}
+	return p
+}
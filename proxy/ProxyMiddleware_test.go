@@ -0,0 +1,236 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"github.com/linxlib/fw"
+	"github.com/linxlib/fw_middlewares/basic_auth"
+	"github.com/valyala/fasthttp"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestProxy(allow ...string) *ProxyMiddleware {
+	p := &ProxyMiddleware{
+		options:     &ProxyOption{Allow: map[string][]string{"alice": allow}},
+		dialTimeout: time.Second,
+		idleTimeout: 5 * time.Second,
+	}
+	p.client = p.newClient()
+	return p
+}
+
+// newUpstream answers with the headers it received
+func newUpstream(t *testing.T, tlsServer bool) *httptest.Server {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Path", r.URL.RequestURI())
+		for _, name := range []string{"X-Secret", "X-Keep", "Proxy-Authorization"} {
+			if v := r.Header.Get(name); v != "" {
+				w.Header().Set("X-Got-"+name, v)
+			}
+		}
+		_, _ = io.WriteString(w, "upstream "+r.Method)
+	})
+	var srv *httptest.Server
+	if tlsServer {
+		srv = httptest.NewTLSServer(h)
+	} else {
+		srv = httptest.NewServer(h)
+	}
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func proxyRequest(uri string) *fasthttp.RequestCtx {
+	fctx := &fasthttp.RequestCtx{}
+	fctx.Request.Header.SetMethod("GET")
+	fctx.Request.SetRequestURI(uri)
+	fctx.Request.Header.Set("Connection", "keep-alive, X-Secret")
+	fctx.Request.Header.Set("X-Secret", "1")
+	fctx.Request.Header.Set("X-Keep", "1")
+	fctx.Request.Header.Set("Proxy-Authorization", "Basic YWxpY2U6c2VjcmV0")
+	return fctx
+}
+
+func TestRemoveHopHeaders(t *testing.T) {
+	fctx := proxyRequest("http://example.com/")
+	removeHopHeaders(&fctx.Request.Header)
+	for _, name := range []string{"Connection", "X-Secret", "Proxy-Authorization"} {
+		if v := fctx.Request.Header.Peek(name); len(v) > 0 {
+			t.Errorf("%s = %q, want removed", name, v)
+		}
+	}
+	if v := fctx.Request.Header.Peek("X-Keep"); string(v) != "1" {
+		t.Errorf("X-Keep = %q, want 1", v)
+	}
+}
+
+func TestIsProxyRequest(t *testing.T) {
+	for _, tt := range []struct {
+		method string
+		uri    string
+		want   bool
+	}{
+		{"GET", "http://example.com/a", true},
+		{"GET", "https://example.com/a", true},
+		{"GET", "HTTPS://example.com/a", true},
+		{"CONNECT", "example.com:443", true},
+		{"GET", "/a", false},
+		{"GET", "/http://example.com", false},
+	} {
+		raw := tt.method + " " + tt.uri + " HTTP/1.1\r\nHost: example.com\r\n\r\n"
+		fctx := &fasthttp.RequestCtx{}
+		if err := fctx.Request.Read(bufio.NewReader(strings.NewReader(raw))); err != nil {
+			t.Fatal(err)
+		}
+		if got := isProxyRequest(fctx); got != tt.want {
+			t.Errorf("%s %s: got %v, want %v", tt.method, tt.uri, got, tt.want)
+		}
+	}
+}
+
+func TestForward(t *testing.T) {
+	for _, tlsServer := range []bool{false, true} {
+		upstream := newUpstream(t, tlsServer)
+		p := newTestProxy("127.0.0.1")
+		p.client.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+		fctx := proxyRequest(upstream.URL + "/a?b=1")
+		p.forward(fctx, "alice")
+		resp := &fctx.Response
+		if resp.StatusCode() != http.StatusOK || string(resp.Body()) != "upstream GET" {
+			t.Fatalf("%s: %d %q", upstream.URL, resp.StatusCode(), resp.Body())
+		}
+		if v := resp.Header.Peek("X-Path"); string(v) != "/a?b=1" {
+			t.Errorf("%s: path = %q", upstream.URL, v)
+		}
+		if v := resp.Header.Peek("X-Got-X-Keep"); string(v) != "1" {
+			t.Errorf("%s: X-Keep was not forwarded", upstream.URL)
+		}
+		for _, name := range []string{"X-Got-X-Secret", "X-Got-Proxy-Authorization"} {
+			if v := resp.Header.Peek(name); len(v) > 0 {
+				t.Errorf("%s: hop header forwarded, %s = %q", upstream.URL, name, v)
+			}
+		}
+	}
+}
+
+func TestForwardNotAllowed(t *testing.T) {
+	upstream := newUpstream(t, false)
+	p := newTestProxy("example.com")
+	fctx := proxyRequest(upstream.URL + "/")
+	p.forward(fctx, "alice")
+	if code := fctx.Response.StatusCode(); code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403", code)
+	}
+}
+
+func TestTunnel(t *testing.T) {
+	// echo server as destination
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	p := newTestProxy("127.0.0.1")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &fasthttp.Server{Handler: func(fctx *fasthttp.RequestCtx) {
+		p.tunnel(fctx, "alice")
+	}}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Shutdown()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	target := echo.Addr().String()
+	if _, err = io.WriteString(conn, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	var resp fasthttp.Response
+	resp.SkipBody = true
+	if err = resp.Header.Read(r); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		t.Fatalf("CONNECT status = %d", resp.StatusCode())
+	}
+	if _, err = io.WriteString(conn, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("tunnel echoed %q", buf)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	upstream := newUpstream(t, false)
+	p := newTestProxy("127.0.0.1")
+	p.checker = basic_auth.NewChecker(basic_auth.Accounts{"alice": "secret"}, nil)
+	routed := 0
+	h := p.Handler(func(fctx *fasthttp.RequestCtx) {
+		routed++
+		fctx.SetStatusCode(http.StatusMethodNotAllowed)
+	})
+
+	// the app answers its own requests, 405 included
+	app := &fasthttp.RequestCtx{}
+	app.Request.Header.SetMethod("DELETE")
+	app.Request.SetRequestURI("/orders")
+	h(app)
+	if routed != 1 || app.Response.StatusCode() != http.StatusMethodNotAllowed {
+		t.Fatalf("app request: routed %d, status %d", routed, app.Response.StatusCode())
+	}
+
+	proxied := proxyRequest(upstream.URL + "/a")
+	h(proxied)
+	if routed != 1 || string(proxied.Response.Body()) != "upstream GET" {
+		t.Fatalf("proxy request: routed %d, %d %q", routed, proxied.Response.StatusCode(), proxied.Response.Body())
+	}
+
+	anonymous := proxyRequest(upstream.URL + "/a")
+	anonymous.Request.Header.Del("Proxy-Authorization")
+	h(anonymous)
+	if routed != 1 || anonymous.Response.StatusCode() != http.StatusProxyAuthRequired {
+		t.Fatalf("anonymous: routed %d, status %d", routed, anonymous.Response.StatusCode())
+	}
+}
+
+func TestRouterCatchAllOptIn(t *testing.T) {
+	p := newTestProxy()
+	if routes := p.Router(&fw.MiddlewareContext{}); routes != nil {
+		t.Fatalf("catch-all routes registered without catch_all: %d", len(routes))
+	}
+	p.options.CatchAll = true
+	if routes := p.Router(&fw.MiddlewareContext{}); len(routes) != 2 {
+		t.Fatalf("%d routes with catch_all, want 2", len(routes))
+	}
+}
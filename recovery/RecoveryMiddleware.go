@@ -2,11 +2,14 @@ package recovery
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/gookit/color"
 	"github.com/linxlib/conv"
 	"github.com/linxlib/fw"
 	"github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"net/http"
 	"os"
 	"runtime"
 	"strings"
@@ -22,6 +25,22 @@ type RecoveryOptions struct {
 	// whether output to console
 	// won't output stack trace to console unless the environment FW_DEBUG=true
 	Console bool
+	// Renderer writes the response, when nil it is negotiated on Accept,
+	// see NegotiatingRenderer
+	Renderer Renderer
+	// ProblemType is the type of application/problem+json responses, about:blank when empty
+	ProblemType string
+	// RequestIDHeader is read from the request, then from the response, X-Request-Id when empty
+	RequestIDHeader string
+	// Reporters receive every recovered panic in the background, e.g. NewSentryReporter
+	Reporters []PanicReporter
+	// ReportRate is the number of panics reported per minute, DefaultReportRate when 0
+	ReportRate int
+	// OnReportError receives the errors of Reporters, they are logged as warnings when nil
+	OnReportError func(err error)
+	// DedupWindow is how long repeats of a panic with the same fingerprint are logged as counts
+	// instead of full dumps, DefaultDedupWindow when 0, negative to dump every panic
+	DedupWindow time.Duration
 }
 
 var _ fw.IMiddlewareGlobal = (*RecoveryMiddleware)(nil)
@@ -29,9 +48,29 @@ var _ fw.IMiddlewareGlobal = (*RecoveryMiddleware)(nil)
 // RecoveryMiddleware globally recover from panic
 type RecoveryMiddleware struct {
 	*fw.MiddlewareGlobal
-	options *RecoveryOptions
-	Logger  *logrus.Logger `inject:""`
-	isDebug bool
+	options  *RecoveryOptions
+	Logger   *logrus.Logger `inject:""`
+	isDebug  bool
+	renderer Renderer
+	reporter *AsyncReporter // nil without reporters
+	panics   *panicTracker
+	admin    *RecoveryAdminOption
+}
+
+func (s *RecoveryMiddleware) DoInitOnce() {
+	s.LoadConfig("recoveryAdmin", s.admin)
+}
+
+func (s *RecoveryMiddleware) Router(ctx *fw.MiddlewareContext) []*fw.RouteItem {
+	return s.adminRoutes()
+}
+
+// Close reports the queued panics and closes the reporters, call it when the server shuts down
+func (s *RecoveryMiddleware) Close(ctx context.Context) error {
+	if s.reporter == nil {
+		return nil
+	}
+	return s.reporter.Close(ctx)
 }
 
 func (s *RecoveryMiddleware) Execute(ctx *fw.MiddlewareContext) fw.HandlerFunc {
@@ -48,44 +87,55 @@ func (s *RecoveryMiddleware) Execute(ctx *fw.MiddlewareContext) fw.HandlerFunc {
 					errMsg = ""
 				}
 
-				//var brokenPipe bool
-				//if ne, ok := err.(*net.OpError); ok {
-				//	if se, ok := ne.Err.(*os.SyscallError); ok {
-				//		if strings.Contains(strings.ToLower(se.Error()), "broken pipe") || strings.Contains(strings.ToLower(se.Error()), "connection reset by peer") {
-				//			brokenPipe = true
-				//		}
-				//	}
-				//}
+				if s.clientGone(context.GetFastContext(), err) {
+					return
+				}
+				// whatever the handler wrote is incomplete, a custom renderer may not set the status
+				context.GetFastContext().Response.ResetBody()
+				context.GetFastContext().SetStatusCode(http.StatusInternalServerError)
 				stack := stack(3, 12)
-				if s.Logger != nil {
-					//DUMP http request、headers etc.
-					reqStr := &strings.Builder{}
-					reqStr.WriteString(fmt.Sprintf("RemoteIP: %s\n", context.RemoteIP()))
-					reqStr.WriteString(fmt.Sprintf("Host: %s\n", context.GetFastContext().Host()))
-					reqStr.WriteString(fmt.Sprintf("Method: %s\n", context.Method()))
-					reqStr.WriteString(fmt.Sprintf("URI: %s\n", context.GetFastContext().RequestURI()))
-					reqStr.WriteString("Headers:\n")
-					context.GetFastContext().Request.Header.VisitAll(func(k, v []byte) {
-						reqStr.WriteString(fmt.Sprintf(" %s: %s\n", k, v))
-					})
-					reqStr.WriteString(fmt.Sprintf("Body: %s\n", context.GetFastContext().PostBody()))
-
+				callers := frames(3, 12)
+				request := dumpRequest(context.GetFastContext())
+				template := messageTemplate(errMsg)
+				fp := fingerprint(template, callers)
+				requestID := s.requestID(context)
+				// the snapshot is taken now, fasthttp reuses the request after the handler
+				snapshot := snapshotRequest(context.GetFastContext(), requestID)
+				dump, repeats := s.panics.seen(fp, template, callers, snapshot)
+				if s.Logger != nil && !dump {
+					s.Logger.Printf("["+color.HiCyan.Render("Recovery")+"] panic %s repeated %d times in %s: %s",
+						fp, repeats+1, s.panics.window, color.HiRed.Render(errMsg))
+				} else if s.Logger != nil {
 					if s.isDebug {
 
 						s.Logger.Printf(
-							"["+color.HiCyan.Render("Recovery")+"] panic recovered: %s\n"+color.HiYellow.Render("Request:")+"\n%s"+color.HiYellow.Render("Stack Trace:")+"\n%s\n",
+							"["+color.HiCyan.Render("Recovery")+"] panic %s recovered: %s\n"+color.HiYellow.Render("Request:")+"\n%s"+color.HiYellow.Render("Stack Trace:")+"\n%s\n",
+							fp,
 							color.HiRed.Render(errMsg),
-							color.Blue.Render(reqStr.String()),
+							color.Blue.Render(request),
 							color.HiMagenta.Render(conv.String(stack)))
 					} else {
 						s.Logger.Printf(
-							"["+color.HiCyan.Render("Recovery")+"] panic recovered: %s\n%s",
+							"["+color.HiCyan.Render("Recovery")+"] panic %s recovered: %s\n%s",
+							fp,
 							color.HiRed.Render(errMsg),
-							color.Blue.Render(reqStr.String()))
+							color.Blue.Render(request))
 					}
 
 				}
-				context.JSON(500, fw.H{"error": errMsg})
+				info := &PanicInfo{
+					Value:       err,
+					Message:     errMsg,
+					Request:     request,
+					RequestID:   requestID,
+					Fingerprint: fp,
+					Frames:      callers,
+					Debug:       s.isDebug,
+				}
+				if s.reporter != nil {
+					_ = s.reporter.Report(newPanicEvent(context, info, snapshot))
+				}
+				s.renderer.Render(context, info)
 
 			}
 		}()
@@ -93,16 +143,82 @@ func (s *RecoveryMiddleware) Execute(ctx *fw.MiddlewareContext) fw.HandlerFunc {
 	}
 }
 
+// clientGone logs a panic of the client going away, a stack trace does not help and there is
+// nobody to write the response to
+func (s *RecoveryMiddleware) clientGone(fctx *fasthttp.RequestCtx, err any) bool {
+	reason := clientDisconnect(fctx, err)
+	if reason == "" {
+		return false
+	}
+	if s.Logger != nil {
+		s.Logger.Infof("[Recovery] client disconnected (%s): %s %s", reason, fctx.Method(), fctx.RequestURI())
+	}
+	fctx.SetConnectionClose()
+	return true
+}
+
+// dumpRequest formats the request line, headers and body of the panicking request,
+// credentials are redacted and the body is cut after maxReportedBody bytes
+func dumpRequest(fctx *fasthttp.RequestCtx) string {
+	reqStr := &strings.Builder{}
+	reqStr.WriteString(fmt.Sprintf("RemoteIP: %s\n", fctx.RemoteIP()))
+	reqStr.WriteString(fmt.Sprintf("Host: %s\n", fctx.Host()))
+	reqStr.WriteString(fmt.Sprintf("Method: %s\n", fctx.Method()))
+	reqStr.WriteString(fmt.Sprintf("URI: %s\n", fctx.RequestURI()))
+	reqStr.WriteString("Headers:\n")
+	fctx.Request.Header.VisitAll(func(k, v []byte) {
+		reqStr.WriteString(fmt.Sprintf(" %s: %s\n", k, headerValue(k, v)))
+	})
+	reqStr.WriteString(fmt.Sprintf("Body: %s\n", truncateBody(fctx.PostBody())))
+	return reqStr.String()
+}
+
+func (s *RecoveryMiddleware) requestID(context *fw.Context) string {
+	header := defaultRequestIDHeader
+	if s.options.RequestIDHeader != "" {
+		header = s.options.RequestIDHeader
+	}
+	fctx := context.GetFastContext()
+	if id := fctx.Request.Header.Peek(header); len(id) > 0 {
+		return string(id)
+	}
+	return string(fctx.Response.Header.Peek(header))
+}
+
 const recoveryName = "Recovery"
 
-func NewRecoveryMiddleware(o *RecoveryOptions, logger *logrus.Logger) fw.IMiddlewareGlobal {
-	isDebug := os.Getenv("FW_DEBUG") == ""
-	return &RecoveryMiddleware{
+// NewRecoveryMiddleware creates the middleware, keep it to Close it when the server shuts down
+func NewRecoveryMiddleware(o *RecoveryOptions, logger *logrus.Logger) *RecoveryMiddleware {
+	isDebug := os.Getenv("FW_DEBUG") == "true"
+	if o == nil {
+		o = new(RecoveryOptions)
+	}
+	renderer := o.Renderer
+	if renderer == nil {
+		renderer = &NegotiatingRenderer{ProblemType: o.ProblemType, NiceWeb: o.NiceWeb}
+	}
+	s := &RecoveryMiddleware{
 		MiddlewareGlobal: fw.NewMiddlewareGlobal(recoveryName),
 		options:          o,
 		isDebug:          isDebug,
 		Logger:           logger,
+		renderer:         renderer,
+		panics:           newPanicTracker(o.DedupWindow),
+		admin:            new(RecoveryAdminOption),
+	}
+	if len(o.Reporters) > 0 {
+		s.reporter = NewAsyncReporter(o.ReportRate, o.Reporters...)
+		onError := o.OnReportError
+		if onError == nil {
+			onError = func(err error) {
+				if s.Logger != nil {
+					s.Logger.Warnf("[Recovery] report panic failed: %s", err)
+				}
+			}
+		}
+		s.reporter.OnError(onError)
 	}
+	return s
 }
 
 var (
@@ -0,0 +1,71 @@
+package recovery
+
+import (
+	"errors"
+	"github.com/valyala/fasthttp"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// messages of disconnect errors which lost their type, e.g. formatted with %v
+var disconnectMessages = []string{
+	"broken pipe",
+	"connection reset by peer",
+	"connection aborted",
+	"use of closed network connection",
+	"the server closed connection before returning the first response byte",
+}
+
+// disconnectReason returns why err is a dropped connection, e.g. "broken pipe", or an empty string.
+// it does not know whose connection dropped, see clientDisconnect.
+func disconnectReason(err error) string {
+	switch {
+	case errors.Is(err, syscall.EPIPE):
+		return "broken pipe"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection reset by peer"
+	case errors.Is(err, syscall.ECONNABORTED):
+		return "connection aborted"
+	case errors.Is(err, net.ErrClosed), errors.Is(err, io.ErrClosedPipe):
+		return "connection closed"
+	case errors.Is(err, fasthttp.ErrConnectionClosed):
+		return "connection closed"
+	}
+	// fasthttp.ErrBodyStreamWritePanic and wrapped net.OpError only keep the message
+	msg := strings.ToLower(err.Error())
+	for _, m := range disconnectMessages {
+		if strings.Contains(msg, m) {
+			return m
+		}
+	}
+	return ""
+}
+
+// clientDisconnect returns why the recovered value is the client of fctx going away, or an empty string
+// for a real panic. outbound connections of the handler, e.g. to a database or an upstream, drop with the
+// same errors, so only errors of writing the response or of the connection of the request count.
+func clientDisconnect(fctx *fasthttp.RequestCtx, v any) string {
+	err, ok := v.(error)
+	if !ok {
+		return ""
+	}
+	reason := disconnectReason(err)
+	if reason == "" {
+		return ""
+	}
+	var streamPanic *fasthttp.ErrBodyStreamWritePanic
+	if errors.As(err, &streamPanic) {
+		return reason
+	}
+	var op *net.OpError
+	if errors.As(err, &op) && sameAddr(op.Source, fctx.LocalAddr()) && sameAddr(op.Addr, fctx.RemoteAddr()) {
+		return reason
+	}
+	return ""
+}
+
+func sameAddr(a, b net.Addr) bool {
+	return a != nil && b != nil && a.Network() == b.Network() && a.String() == b.String()
+}
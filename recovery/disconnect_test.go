@@ -0,0 +1,134 @@
+package recovery
+
+import (
+	"errors"
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDisconnectReason(t *testing.T) {
+	opError := func(errno syscall.Errno) error {
+		return &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", errno)}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"EPIPE", syscall.EPIPE, "broken pipe"},
+		{"ECONNRESET", syscall.ECONNRESET, "connection reset by peer"},
+		{"ECONNABORTED", syscall.ECONNABORTED, "connection aborted"},
+		{"net.ErrClosed", net.ErrClosed, "connection closed"},
+		{"fasthttp.ErrConnectionClosed", fasthttp.ErrConnectionClosed, "connection closed"},
+		{"wrapped OpError EPIPE", fmt.Errorf("write response: %w", opError(syscall.EPIPE)), "broken pipe"},
+		{"wrapped OpError ECONNRESET", fmt.Errorf("write response: %w", opError(syscall.ECONNRESET)), "connection reset by peer"},
+		{"OpError formatted with %v", fmt.Errorf("write response: %v", opError(syscall.EPIPE)), "broken pipe"},
+		{"not a disconnect", errors.New("assignment to entry in nil map"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := disconnectReason(tt.err); got != tt.want {
+				t.Errorf("disconnectReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// serverConn returns the server side of a loopback connection whose client is gone
+func serverConn(t *testing.T) net.Conn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("no loopback:", err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	// a linger of 0 resets the connection instead of closing it
+	client.(*net.TCPConn).SetLinger(0)
+	client.Close()
+	return server
+}
+
+// writeUntilError writes to a connection whose peer is gone until the kernel reports it
+func writeUntilError(t *testing.T, conn net.Conn) error {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := conn.Write([]byte("HTTP/1.1 200 OK\r\n\r\n")); err != nil {
+			return err
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("write to a closed connection did not fail")
+	return nil
+}
+
+func TestClientDisconnect(t *testing.T) {
+	server := serverConn(t)
+	fctx := new(fasthttp.RequestCtx)
+	fctx.Init2(server, nil, false)
+	writeErr := writeUntilError(t, server)
+	if disconnectReason(writeErr) == "" {
+		t.Fatalf("unexpected write error %v", writeErr)
+	}
+
+	// the same error from another connection, e.g. the database
+	other := serverConn(t)
+	outboundErr := writeUntilError(t, other)
+
+	tests := []struct {
+		name       string
+		value      any
+		disconnect bool
+	}{
+		{"write to the request connection", writeErr, true},
+		{"wrapped write to the request connection", fmt.Errorf("flush: %w", writeErr), true},
+		{"outbound connection", outboundErr, false},
+		{"outbound reset formatted", errors.New("query: read tcp 10.0.0.1:5432: connection reset by peer"), false},
+		{"string panic", "broken pipe", false},
+		{"real panic", errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clientDisconnect(fctx, tt.value) != ""; got != tt.disconnect {
+				t.Errorf("clientDisconnect() = %v, want %v", got, tt.disconnect)
+			}
+		})
+	}
+}
+
+func TestClientGoneWritesNothing(t *testing.T) {
+	server := serverConn(t)
+	fctx := new(fasthttp.RequestCtx)
+	fctx.Init2(server, nil, false)
+	err := writeUntilError(t, server)
+
+	s := NewRecoveryMiddleware(nil, nil)
+	if !s.clientGone(fctx, err) {
+		t.Fatalf("%v is not recognized as a disconnect", err)
+	}
+	if body := fctx.Response.Body(); len(body) != 0 {
+		t.Errorf("body written: %q", body)
+	}
+	if code := fctx.Response.StatusCode(); code != fasthttp.StatusOK {
+		t.Errorf("status changed to %d", code)
+	}
+	if !fctx.Response.ConnectionClose() {
+		t.Error("connection is kept alive")
+	}
+
+	if s.clientGone(fctx, errors.New("boom")) {
+		t.Error("a real panic is treated as a disconnect")
+	}
+}
@@ -0,0 +1,160 @@
+package recovery
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/linxlib/fw"
+	"github.com/linxlib/fw_middlewares/auth"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// frames of the panicking code which make up the fingerprint
+	fingerprintFrames = 5
+	// distinct fingerprints tracked, panics beyond are always dumped
+	maxFingerprints = 1000
+	// DefaultDedupWindow is used when RecoveryOptions.DedupWindow is 0
+	DefaultDedupWindow = time.Minute
+)
+
+type RecoveryAdminOption struct {
+	Key  string `yaml:"key" default:""` // sent in the X-Admin-Key header, the route is disabled when empty
+	Path string `yaml:"path" default:"/recovery/panics"`
+}
+
+var (
+	hexPattern    = regexp.MustCompile(`0x[0-9a-fA-F]+`)
+	numberPattern = regexp.MustCompile(`\d+`)
+)
+
+// messageTemplate replaces addresses and numbers, so "index out of range [5] with length 3"
+// and "index out of range [7] with length 4" are the same panic
+func messageTemplate(message string) string {
+	message = hexPattern.ReplaceAllString(message, "0x?")
+	return numberPattern.ReplaceAllString(message, "N")
+}
+
+// fingerprint identifies a panic by the template of its message and the top frames of the code
+// which panicked, frames of the runtime are skipped
+func fingerprint(template string, frames []Frame) string {
+	h := sha1.New()
+	h.Write([]byte(template))
+	n := 0
+	for _, f := range frames {
+		if n == fingerprintFrames {
+			break
+		}
+		if fn := runtime.FuncForPC(f.PC); fn != nil && strings.HasPrefix(fn.Name(), "runtime.") {
+			continue
+		}
+		h.Write([]byte("\n" + f.Function + " " + filepath.Base(f.File) + ":" + strconv.Itoa(f.Line)))
+		n++
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// PanicStats are the occurrences of panics with the same fingerprint
+type PanicStats struct {
+	Fingerprint   string          `json:"fingerprint"`
+	Message       string          `json:"message"` // template of the message
+	Location      string          `json:"location"`
+	FirstSeen     time.Time       `json:"first_seen"`
+	LastSeen      time.Time       `json:"last_seen"`
+	Count         int64           `json:"count"`
+	SampleRequest RequestSnapshot `json:"sample_request"` // the first request, credentials are redacted
+	windowStart   time.Time
+	windowCount   int64
+}
+
+// panicTracker counts panics per fingerprint and decides which ones are dumped
+type panicTracker struct {
+	window time.Duration // dedup is disabled when negative
+	stats  map[string]*PanicStats
+	mu     sync.Mutex
+}
+
+func newPanicTracker(window time.Duration) *panicTracker {
+	if window == 0 {
+		window = DefaultDedupWindow
+	}
+	return &panicTracker{window: window, stats: make(map[string]*PanicStats)}
+}
+
+// seen records a panic and reports whether its full dump should be logged, that is the first time
+// in a window. repeats is the number of occurrences in the current window before this one.
+func (t *panicTracker) seen(fp string, template string, frames []Frame, request RequestSnapshot) (dump bool, repeats int64) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st, ok := t.stats[fp]
+	if !ok {
+		if len(t.stats) >= maxFingerprints {
+			return true, 0
+		}
+		st = &PanicStats{Fingerprint: fp, Message: template, FirstSeen: now, SampleRequest: request}
+		if len(frames) > 0 {
+			st.Location = frames[0].Function + " " + frames[0].File + ":" + strconv.Itoa(frames[0].Line)
+		}
+		t.stats[fp] = st
+	}
+	st.Count++
+	st.LastSeen = now
+	if t.window < 0 || !ok || now.Sub(st.windowStart) >= t.window {
+		st.windowStart = now
+		st.windowCount = 1
+		return true, 0
+	}
+	repeats = st.windowCount
+	st.windowCount++
+	return false, repeats
+}
+
+func (t *panicTracker) snapshot() []PanicStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := make([]PanicStats, 0, len(t.stats))
+	for _, st := range t.stats {
+		stats = append(stats, *st)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Count > stats[j].Count
+	})
+	return stats
+}
+
+func (t *panicTracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stats = make(map[string]*PanicStats)
+}
+
+// adminRoutes lists and resets the panic statistics
+func (s *RecoveryMiddleware) adminRoutes() []*fw.RouteItem {
+	return auth.AdminRoutes(s.admin.Key,
+		&fw.RouteItem{
+			Method: "GET",
+			Path:   s.admin.Path,
+			H: func(context *fw.Context) {
+				context.JSON(http.StatusOK, fw.H{"panics": s.panics.snapshot()})
+			},
+			Middleware: s,
+		},
+		&fw.RouteItem{
+			Method: "DELETE",
+			Path:   s.admin.Path,
+			H: func(context *fw.Context) {
+				s.panics.reset()
+				context.String(http.StatusOK, "ok")
+			},
+			Middleware: s,
+		},
+	)
+}
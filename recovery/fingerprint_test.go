@@ -0,0 +1,47 @@
+package recovery
+
+import (
+	"github.com/valyala/fasthttp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSampleRequestIsRedacted(t *testing.T) {
+	fctx := new(fasthttp.RequestCtx)
+	fctx.Request.SetRequestURI("/panic")
+	fctx.Request.Header.SetMethod("POST")
+	fctx.Request.Header.Set("Authorization", "Bearer secret-token")
+	fctx.Request.Header.Set("Cookie", "session=secret-cookie")
+	fctx.Request.SetBody([]byte(strings.Repeat("x", 2*maxReportedBody)))
+
+	tracker := newPanicTracker(time.Minute)
+	tracker.seen("fp", "boom", nil, snapshotRequest(fctx, ""))
+	stats := tracker.snapshot()
+	if len(stats) != 1 {
+		t.Fatalf("got %d stats", len(stats))
+	}
+	sample := stats[0].SampleRequest
+	for k, v := range sample.Headers {
+		if strings.Contains(v, "secret") {
+			t.Errorf("header %s not redacted: %s", k, v)
+		}
+	}
+	if len(sample.Body) != maxReportedBody {
+		t.Errorf("body not cut: %d bytes", len(sample.Body))
+	}
+}
+
+func TestTrackerDumpsOncePerWindow(t *testing.T) {
+	tracker := newPanicTracker(time.Minute)
+	if dump, _ := tracker.seen("fp", "boom", nil, RequestSnapshot{}); !dump {
+		t.Fatal("first panic not dumped")
+	}
+	dump, repeats := tracker.seen("fp", "boom", nil, RequestSnapshot{})
+	if dump || repeats != 1 {
+		t.Fatalf("repeat: dump %v repeats %d", dump, repeats)
+	}
+	if got := messageTemplate("index out of range [5] with length 3"); got != "index out of range [N] with length N" {
+		t.Errorf("template %q", got)
+	}
+}
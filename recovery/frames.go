@@ -0,0 +1,85 @@
+package recovery
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"strconv"
+)
+
+// lines of source shown around the line of a frame
+const sourceContext = 5
+
+// SourceLine is a line of the source file of a frame
+type SourceLine struct {
+	Number  int    `json:"number"`
+	Text    string `json:"text"`
+	Current bool   `json:"current"` // the line of the frame
+}
+
+// Frame is a stack frame of a recovered panic
+type Frame struct {
+	File     string       `json:"file"`
+	Line     int          `json:"line"`
+	PC       uintptr      `json:"pc"`
+	Function string       `json:"function"`
+	Source   []SourceLine `json:"source,omitempty"` // empty when the file can not be read
+}
+
+// frames returns the stack frames skipping skip frames, like stack, with the source around every line
+func frames(skip int, max int) []Frame {
+	var result []Frame
+	var lines [][]byte
+	var lastFile string
+	for i := skip; i <= max; i++ {
+		pc, file, line, ok := runtime.Caller(i)
+		if !ok {
+			break
+		}
+		frame := Frame{File: file, Line: line, PC: pc, Function: string(function(pc))}
+		if file != lastFile {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				lines = nil
+			} else {
+				lines = bytes.Split(data, []byte{'\n'})
+			}
+			lastFile = file
+		}
+		frame.Source = sourceAround(lines, line, sourceContext)
+		result = append(result, frame)
+	}
+	return result
+}
+
+// sourceAround returns the n'th line with around lines before and after it
+func sourceAround(lines [][]byte, n int, around int) []SourceLine {
+	if n < 1 || n > len(lines) {
+		return nil
+	}
+	from := max(n-around, 1)
+	to := min(n+around, len(lines))
+	result := make([]SourceLine, 0, to-from+1)
+	for i := from; i <= to; i++ {
+		result = append(result, SourceLine{
+			Number:  i,
+			Text:    string(bytes.TrimRight(lines[i-1], "\r")),
+			Current: i == n,
+		})
+	}
+	return result
+}
+
+// goroutineID reads the id of the current goroutine from the header of its stack,
+// it is only meant for diagnostics
+func goroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	// goroutine 123 [running]:
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		buf = buf[:i]
+	}
+	id, _ := strconv.ParseUint(string(buf), 10, 64)
+	return id
+}
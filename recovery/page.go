@@ -0,0 +1,105 @@
+package recovery
+
+import (
+	"bytes"
+	"html/template"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// errorPage is rendered by errorTemplate when NiceWeb is set
+type errorPage struct {
+	Message    string
+	Method     string
+	URI        string
+	Time       string
+	Request    string // only shown in debug mode, the body may hold credentials
+	Debug      bool
+	Goroutine  uint64
+	Goroutines int
+	Frames     []Frame
+	Trace      string // stack of the panicking goroutine as printed by the runtime
+}
+
+func newErrorPage(message string, method string, uri string, request string, frames []Frame, isDebug bool) *errorPage {
+	page := &errorPage{
+		Message:    message,
+		Method:     method,
+		URI:        uri,
+		Time:       timeFormat(time.Now()),
+		Debug:      isDebug,
+		Goroutine:  goroutineID(),
+		Goroutines: runtime.NumGoroutine(),
+	}
+	if isDebug {
+		page.Request = request
+		page.Frames = frames
+		page.Trace = string(debug.Stack())
+	}
+	return page
+}
+
+func (p *errorPage) render() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := errorTemplate.Execute(&buf, p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var errorTemplate = template.Must(template.New("recovery").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>500 Internal Server Error</title>
+<style>
+body{margin:0;font-family:-apple-system,"Segoe UI",Helvetica,Arial,sans-serif;background:#f5f5f5;color:#222}
+header{background:#c0392b;color:#fff;padding:24px 32px}
+header h1{margin:0 0 8px;font-size:20px;font-weight:normal}
+header .message{margin:0;font-size:16px;font-family:Menlo,Consolas,monospace;white-space:pre-wrap;word-break:break-all}
+main{padding:16px 32px}
+details{background:#fff;border:1px solid #ddd;border-radius:4px;margin-bottom:12px}
+summary{cursor:pointer;padding:10px 14px;font-weight:bold}
+pre{margin:0;padding:10px 14px;overflow:auto;font-family:Menlo,Consolas,monospace;font-size:12px;line-height:1.5}
+.meta{color:#666;font-size:13px}
+.frame summary{font-weight:normal;font-family:Menlo,Consolas,monospace;font-size:13px}
+.frame .file{color:#666}
+.line{display:block}
+.line .no{display:inline-block;width:48px;color:#999;text-align:right;margin-right:12px}
+.current{background:#fdecea}
+</style>
+</head>
+<body>
+<header>
+<h1>500 Internal Server Error</h1>
+<p class="message">{{.Message}}</p>
+</header>
+<main>
+<p class="meta">{{.Method}} {{.URI}} &middot; {{.Time}} &middot; goroutine {{.Goroutine}} of {{.Goroutines}}</p>
+{{if .Debug}}
+<details open>
+<summary>Request</summary>
+<pre>{{.Request}}</pre>
+</details>
+<details open>
+<summary>Stack ({{len .Frames}} frames)</summary>
+{{range $i, $f := .Frames}}
+<details class="frame"{{if eq $i 0}} open{{end}}>
+<summary>{{$f.Function}} <span class="file">{{$f.File}}:{{$f.Line}}</span></summary>
+{{if $f.Source}}<pre>{{range $f.Source}}<span class="line{{if .Current}} current{{end}}"><span class="no">{{.Number}}</span>{{.Text}}</span>{{end}}</pre>{{end}}
+</details>
+{{end}}
+</details>
+<details>
+<summary>Goroutine {{.Goroutine}}</summary>
+<pre>{{.Trace}}</pre>
+</details>
+{{else}}
+<p class="meta">request, stack and source are shown in debug mode</p>
+{{end}}
+</main>
+</body>
+</html>
+`))
@@ -0,0 +1,73 @@
+package recovery
+
+import (
+	"github.com/valyala/fasthttp"
+	"strings"
+	"testing"
+)
+
+func TestDebugNeedsFwDebugTrue(t *testing.T) {
+	for _, env := range []string{"", "false", "1"} {
+		t.Setenv("FW_DEBUG", env)
+		s := NewRecoveryMiddleware(nil, nil)
+		if s.isDebug {
+			t.Errorf("FW_DEBUG=%q enables debug", env)
+		}
+	}
+	t.Setenv("FW_DEBUG", "true")
+	if s := NewRecoveryMiddleware(nil, nil); !s.isDebug {
+		t.Error("FW_DEBUG=true does not enable debug")
+	}
+}
+
+func TestErrorPageHidesFramesWithoutDebug(t *testing.T) {
+	t.Setenv("FW_DEBUG", "")
+	s := NewRecoveryMiddleware(nil, nil)
+	callers := frames(0, 3)
+	if len(callers) == 0 || len(callers[0].Source) == 0 {
+		t.Fatal("expected frames with source")
+	}
+	page := newErrorPage("boom", "GET", "/panic", "Method: GET\n", callers, s.isDebug)
+	if page.Frames != nil || page.Trace != "" {
+		t.Fatalf("frames or trace set without debug: %d frames", len(page.Frames))
+	}
+	bs, err := page.render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(bs)
+	if strings.Contains(html, callers[0].File) || strings.Contains(html, "goroutine 1 [") {
+		t.Error("page shows stack or source without debug")
+	}
+	if !strings.Contains(html, "boom") {
+		t.Error("page misses the panic message")
+	}
+}
+
+func TestDumpRequestRedactsCredentials(t *testing.T) {
+	fctx := new(fasthttp.RequestCtx)
+	fctx.Request.SetRequestURI("/panic")
+	fctx.Request.Header.Set("Authorization", "Bearer secret-token")
+	fctx.Request.Header.Set("Cookie", "session=secret-cookie")
+	fctx.Request.Header.Set("Proxy-Authorization", "Basic secret-proxy")
+	fctx.Request.Header.Set("X-Trace", "visible")
+	dump := dumpRequest(fctx)
+	for _, secret := range []string{"secret-token", "secret-cookie", "secret-proxy"} {
+		if strings.Contains(dump, secret) {
+			t.Errorf("dump contains %s:\n%s", secret, dump)
+		}
+	}
+	if !strings.Contains(dump, "visible") {
+		t.Errorf("dump misses other headers:\n%s", dump)
+	}
+}
+
+func TestErrorPageHidesRequestWithoutDebug(t *testing.T) {
+	bs, err := newErrorPage("boom", "POST", "/panic", "Body: password=hunter2\n", nil, false).render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(bs), "hunter2") {
+		t.Error("page shows the request dump without debug")
+	}
+}
@@ -0,0 +1,31 @@
+package recovery
+
+import "strings"
+
+// bodies of dumps and snapshots are cut after maxReportedBody bytes
+const maxReportedBody = 4096
+
+// headers which are never logged, shown or reported
+var redactedHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"set-cookie":          true,
+	"x-api-key":           true,
+	"x-csrf-token":        true,
+}
+
+// headerValue returns v or a placeholder for credentials
+func headerValue(key []byte, v []byte) string {
+	if redactedHeaders[strings.ToLower(string(key))] {
+		return "[redacted]"
+	}
+	return string(v)
+}
+
+func truncateBody(body []byte) []byte {
+	if len(body) > maxReportedBody {
+		return body[:maxReportedBody]
+	}
+	return body
+}
@@ -0,0 +1,160 @@
+package recovery
+
+import (
+	"encoding/json"
+	"github.com/linxlib/conv"
+	"github.com/linxlib/fw"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	mimeProblemJSON = "application/problem+json"
+	mimeJSON        = "application/json"
+	mimeHTML        = "text/html"
+	mimeText        = "text/plain"
+
+	defaultRequestIDHeader = "X-Request-Id"
+)
+
+// PanicInfo describes a recovered panic
+type PanicInfo struct {
+	Value     any    // the recovered value
+	Message   string // the error or string of Value
+	Request   string // dump of the request line, headers and body
+	RequestID string
+	// Fingerprint is the same for panics of the same code and message template
+	Fingerprint string
+	Frames      []Frame
+	Debug       bool // frames may be shown to the client
+}
+
+// Problem is a RFC 9457 problem detail
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Renderer writes the response of a recovered panic, e.g. in the error envelope of a team
+type Renderer interface {
+	Render(context *fw.Context, info *PanicInfo)
+}
+
+// RendererFunc adapts a function to a Renderer
+type RendererFunc func(context *fw.Context, info *PanicInfo)
+
+func (f RendererFunc) Render(context *fw.Context, info *PanicInfo) {
+	f(context, info)
+}
+
+// NegotiatingRenderer answers with problem+json, json, html or plain text depending on the Accept header
+type NegotiatingRenderer struct {
+	// ProblemType is the type of problem responses, about:blank when empty
+	ProblemType string
+	// NiceWeb offers the html error page, it is preferred when the client accepts anything
+	NiceWeb bool
+}
+
+func (r *NegotiatingRenderer) Render(context *fw.Context, info *PanicInfo) {
+	offers := []string{mimeJSON, mimeProblemJSON, mimeText}
+	if r.NiceWeb {
+		// the page is also the answer to */*
+		offers = []string{mimeHTML, mimeJSON, mimeProblemJSON, mimeText}
+	}
+	accept := conv.String(context.GetFastContext().Request.Header.Peek("Accept"))
+	switch negotiate(accept, offers) {
+	case mimeProblemJSON:
+		bs, err := json.Marshal(r.problem(context, info))
+		if err != nil {
+			break
+		}
+		context.Data(http.StatusInternalServerError, mimeProblemJSON, bs)
+		return
+	case mimeHTML:
+		page := newErrorPage(info.Message, context.Method(), conv.String(context.GetFastContext().RequestURI()),
+			info.Request, info.Frames, info.Debug)
+		bs, err := page.render()
+		if err != nil {
+			break
+		}
+		context.Data(http.StatusInternalServerError, "text/html; charset=utf-8", bs)
+		return
+	case mimeText:
+		text := http.StatusText(http.StatusInternalServerError) + ": " + info.Message
+		if info.RequestID != "" {
+			text += "\nrequest id: " + info.RequestID
+		}
+		context.String(http.StatusInternalServerError, text)
+		return
+	}
+	context.JSON(http.StatusInternalServerError, fw.H{"error": info.Message})
+}
+
+func (r *NegotiatingRenderer) problem(context *fw.Context, info *PanicInfo) *Problem {
+	problemType := r.ProblemType
+	if problemType == "" {
+		problemType = "about:blank"
+	}
+	return &Problem{
+		Type:      problemType,
+		Title:     http.StatusText(http.StatusInternalServerError),
+		Status:    http.StatusInternalServerError,
+		Detail:    info.Message,
+		Instance:  conv.String(context.GetFastContext().Path()),
+		RequestID: info.RequestID,
+	}
+}
+
+// negotiate returns the offer with the highest quality in accept, the first offer wins ties
+// and is returned when accept is empty. it returns an empty string when no offer is acceptable.
+func negotiate(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := quality(accept, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// quality returns the q value of the most specific media range of accept matching offer
+func quality(accept string, offer string) float64 {
+	offerType, offerSub, _ := strings.Cut(offer, "/")
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaRange, params, _ := strings.Cut(part, ";")
+		mediaType, subType, _ := strings.Cut(strings.ToLower(strings.TrimSpace(mediaRange)), "/")
+		var s int
+		switch {
+		case mediaType == offerType && subType == offerSub:
+			s = 2
+		case mediaType == offerType && subType == "*":
+			s = 1
+		case mediaType == "*" && subType == "*":
+			s = 0
+		default:
+			continue
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity, q = s, 1
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				if v, err := strconv.ParseFloat(value, 64); err == nil {
+					q = v
+				}
+			}
+		}
+	}
+	return q
+}
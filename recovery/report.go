@@ -0,0 +1,252 @@
+package recovery
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/linxlib/conv"
+	"github.com/linxlib/fw"
+	"github.com/linxlib/fw_middlewares/auth"
+	"github.com/valyala/fasthttp"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// events waiting for the reporters, more are dropped
+	reportQueueSize = 100
+	// DefaultReportRate is the number of events reported per minute when RecoveryOptions.ReportRate is 0
+	DefaultReportRate = 60
+)
+
+// RequestSnapshot is a copy of the panicking request, fasthttp reuses the request once the handler returns
+type RequestSnapshot struct {
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Path      string            `json:"path"`
+	Query     string            `json:"query,omitempty"`
+	RemoteIP  string            `json:"remote_ip"`
+	RequestID string            `json:"request_id,omitempty"`
+	Headers   map[string]string `json:"headers"` // credentials are redacted
+	Body      string            `json:"body,omitempty"`
+}
+
+// PanicEvent is a recovered panic as reporters receive it
+type PanicEvent struct {
+	ID          string          `json:"id"` // 32 hex characters
+	Fingerprint string          `json:"fingerprint"`
+	Time        time.Time       `json:"time"`
+	Message     string          `json:"message"`
+	Type        string          `json:"type"` // go type of the recovered value
+	Goroutine   uint64          `json:"goroutine"`
+	Frames      []Frame         `json:"frames"`
+	Request     RequestSnapshot `json:"request"`
+	Principal   *auth.Principal `json:"principal,omitempty"`
+}
+
+// PanicReporter sends recovered panics somewhere people get alerted, e.g. Sentry
+type PanicReporter interface {
+	Report(event *PanicEvent) error
+}
+
+// PanicReporterFunc adapts a function to a PanicReporter
+type PanicReporterFunc func(event *PanicEvent) error
+
+func (f PanicReporterFunc) Report(event *PanicEvent) error {
+	return f(event)
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func snapshotRequest(fctx *fasthttp.RequestCtx, requestID string) RequestSnapshot {
+	snapshot := RequestSnapshot{
+		Method:    conv.String(fctx.Method()),
+		URL:       fctx.URI().String(),
+		Path:      string(fctx.Path()),
+		Query:     string(fctx.QueryArgs().QueryString()),
+		RemoteIP:  fctx.RemoteIP().String(),
+		RequestID: requestID,
+		Headers:   make(map[string]string),
+	}
+	fctx.Request.Header.VisitAll(func(k, v []byte) {
+		snapshot.Headers[string(k)] = headerValue(k, v)
+	})
+	snapshot.Body = string(truncateBody(fctx.PostBody()))
+	return snapshot
+}
+
+func newPanicEvent(context *fw.Context, info *PanicInfo, request RequestSnapshot) *PanicEvent {
+	return &PanicEvent{
+		ID:          newEventID(),
+		Fingerprint: info.Fingerprint,
+		Time:        time.Now(),
+		Message:     info.Message,
+		Type:        fmt.Sprintf("%T", info.Value),
+		Goroutine:   goroutineID(),
+		Frames:      info.Frames,
+		Request:     request,
+		Principal:   auth.FromContext(context),
+	}
+}
+
+// limiter is a token bucket refilled with rate tokens per minute
+type limiter struct {
+	tokens float64
+	burst  float64
+	perSec float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+func newLimiter(rate int) *limiter {
+	return &limiter{tokens: float64(rate), burst: float64(rate), perSec: float64(rate) / 60, last: time.Now()}
+}
+
+func (l *limiter) allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.perSec)
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// AsyncReporter hands events to reporters in the background so the response is not delayed,
+// events over the rate or the queue are dropped and counted. Close drains the queue.
+type AsyncReporter struct {
+	reporters []PanicReporter
+	queue     chan *PanicEvent
+	limiter   *limiter
+	dropped   atomic.Int64
+	onError   atomic.Pointer[func(err error)]
+	closed    bool
+	mu        sync.RWMutex // guards closed and sends to queue
+	done      chan struct{}
+}
+
+// NewAsyncReporter reports at most rate events per minute to every reporter, DefaultReportRate when rate is 0
+func NewAsyncReporter(rate int, reporters ...PanicReporter) *AsyncReporter {
+	if rate <= 0 {
+		rate = DefaultReportRate
+	}
+	r := &AsyncReporter{
+		reporters: reporters,
+		queue:     make(chan *PanicEvent, reportQueueSize),
+		limiter:   newLimiter(rate),
+		done:      make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// OnError sets the handler of reporter errors, they are dropped without one
+func (r *AsyncReporter) OnError(fn func(err error)) {
+	r.onError.Store(&fn)
+}
+
+// Report queues the event, it never blocks. events reported after Close are dropped.
+func (r *AsyncReporter) Report(event *PanicEvent) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		r.dropped.Add(1)
+		return nil
+	}
+	if !r.limiter.allow() {
+		r.dropped.Add(1)
+		return nil
+	}
+	select {
+	case r.queue <- event:
+	default:
+		r.dropped.Add(1)
+	}
+	return nil
+}
+
+// Dropped returns the number of events dropped by the rate limit or a full queue
+func (r *AsyncReporter) Dropped() int64 {
+	return r.dropped.Load()
+}
+
+// Close stops accepting events and waits until the queued ones are reported, or until ctx is done.
+// reporters which are an io.Closer, e.g. FileReporter, are closed once the queue is drained.
+func (r *AsyncReporter) Close(ctx context.Context) error {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+	}
+	r.mu.Unlock()
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *AsyncReporter) run() {
+	defer close(r.done)
+	for event := range r.queue {
+		for _, reporter := range r.reporters {
+			if err := reporter.Report(event); err != nil {
+				r.handleError(err)
+			}
+		}
+	}
+	for _, reporter := range r.reporters {
+		if closer, ok := reporter.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				r.handleError(err)
+			}
+		}
+	}
+}
+
+func (r *AsyncReporter) handleError(err error) {
+	if fn := r.onError.Load(); fn != nil {
+		(*fn)(err)
+	}
+}
+
+// postJSON is shared by the http reporters
+func postJSON(client *http.Client, url string, contentType string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("recovery: %s answered %s", url, resp.Status)
+	}
+	return nil
+}
+
+func defaultClient(client []*http.Client) *http.Client {
+	if len(client) > 0 && client[0] != nil {
+		return client[0]
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
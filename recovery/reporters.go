@@ -0,0 +1,216 @@
+package recovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SentryReporter sends events to Sentry or any server accepting Sentry envelopes,
+// see https://develop.sentry.dev/sdk/data-model/envelopes/
+type SentryReporter struct {
+	// Environment and Release are added to every event when set
+	Environment string
+	Release     string
+	dsn         string
+	endpoint    string
+	auth        string
+	client      *http.Client
+}
+
+// NewSentryReporter parses dsn, e.g. https://key@o0.ingest.sentry.io/42 or http://key@127.0.0.1:9000/1
+func NewSentryReporter(dsn string, client ...*http.Client) (*SentryReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, errors.New("recovery: invalid sentry dsn: " + err.Error())
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("recovery: sentry dsn has no public key")
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	if i < 0 || path[i+1:] == "" {
+		return nil, errors.New("recovery: sentry dsn has no project id")
+	}
+	prefix, project := path[:i], path[i+1:]
+	return &SentryReporter{
+		dsn:      dsn,
+		endpoint: u.Scheme + "://" + u.Host + prefix + "/api/" + project + "/envelope/",
+		auth:     "Sentry sentry_version=7, sentry_client=fw-recovery/1.0, sentry_key=" + u.User.Username(),
+		client:   defaultClient(client),
+	}, nil
+}
+
+type sentryFrame struct {
+	Filename    string   `json:"filename"`
+	AbsPath     string   `json:"abs_path"`
+	Function    string   `json:"function"`
+	Module      string   `json:"module,omitempty"`
+	Lineno      int      `json:"lineno"`
+	PreContext  []string `json:"pre_context,omitempty"`
+	ContextLine string   `json:"context_line,omitempty"`
+	PostContext []string `json:"post_context,omitempty"`
+	InApp       bool     `json:"in_app"`
+}
+
+// sentryFrames converts frames, sentry wants the outermost frame first
+func sentryFrames(frames []Frame) []sentryFrame {
+	result := make([]sentryFrame, 0, len(frames))
+	for i := len(frames) - 1; i >= 0; i-- {
+		f := frames[i]
+		sf := sentryFrame{
+			Filename: f.File,
+			AbsPath:  f.File,
+			Function: f.Function,
+			Lineno:   f.Line,
+			InApp:    true,
+		}
+		if i := strings.LastIndex(f.File, "/"); i >= 0 {
+			sf.Filename = f.File[i+1:]
+		}
+		if fn := runtime.FuncForPC(f.PC); fn != nil {
+			name := fn.Name()
+			sf.InApp = !strings.HasPrefix(name, "runtime.")
+			if i := strings.LastIndex(name, "/"); i >= 0 {
+				if j := strings.Index(name[i:], "."); j >= 0 {
+					sf.Module = name[:i+j]
+				}
+			} else if j := strings.Index(name, "."); j >= 0 {
+				sf.Module = name[:j]
+			}
+		}
+		for _, line := range f.Source {
+			switch {
+			case line.Current:
+				sf.ContextLine = line.Text
+			case line.Number < f.Line:
+				sf.PreContext = append(sf.PreContext, line.Text)
+			default:
+				sf.PostContext = append(sf.PostContext, line.Text)
+			}
+		}
+		result = append(result, sf)
+	}
+	return result
+}
+
+func (s *SentryReporter) sentryEvent(event *PanicEvent) map[string]any {
+	e := map[string]any{
+		"event_id":    event.ID,
+		"fingerprint": []string{event.Fingerprint},
+		"timestamp":   event.Time.UTC().Format(time.RFC3339Nano),
+		"level":       "fatal",
+		"platform":    "go",
+		"logger":      "recovery",
+		"exception": map[string]any{
+			"values": []any{map[string]any{
+				"type":       event.Type,
+				"value":      event.Message,
+				"mechanism":  map[string]any{"type": "recovery", "handled": true},
+				"stacktrace": map[string]any{"frames": sentryFrames(event.Frames)},
+			}},
+		},
+		"request": map[string]any{
+			"url":          event.Request.URL,
+			"method":       event.Request.Method,
+			"query_string": event.Request.Query,
+			"headers":      event.Request.Headers,
+			"data":         event.Request.Body,
+			"env":          map[string]string{"REMOTE_ADDR": event.Request.RemoteIP},
+		},
+		"tags": map[string]string{"goroutine": strconv.FormatUint(event.Goroutine, 10), "request_id": event.Request.RequestID},
+	}
+	if s.Environment != "" {
+		e["environment"] = s.Environment
+	}
+	if s.Release != "" {
+		e["release"] = s.Release
+	}
+	if p := event.Principal; p != nil {
+		e["user"] = map[string]any{"id": p.ID, "username": p.DisplayName(), "data": map[string]any{"method": p.Method, "roles": p.Roles}}
+	}
+	return e
+}
+
+func (s *SentryReporter) Report(event *PanicEvent) error {
+	header, err := json.Marshal(map[string]string{
+		"event_id": event.ID,
+		"dsn":      s.dsn,
+		"sent_at":  time.Now().UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(s.sentryEvent(event))
+	if err != nil {
+		return err
+	}
+	item, _ := json.Marshal(map[string]any{"type": "event", "length": len(payload)})
+	var body bytes.Buffer
+	body.Write(header)
+	body.WriteByte('\n')
+	body.Write(item)
+	body.WriteByte('\n')
+	body.Write(payload)
+	body.WriteByte('\n')
+	return postJSON(s.client, s.endpoint, "application/x-sentry-envelope", body.Bytes(), map[string]string{"X-Sentry-Auth": s.auth})
+}
+
+// WebhookReporter posts every event as json
+type WebhookReporter struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// NewWebhookReporter posts to url with headers, e.g. an Authorization header of the receiver
+func NewWebhookReporter(url string, headers map[string]string, client ...*http.Client) *WebhookReporter {
+	return &WebhookReporter{url: url, headers: headers, client: defaultClient(client)}
+}
+
+func (w *WebhookReporter) Report(event *PanicEvent) error {
+	bs, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return postJSON(w.client, w.url, "application/json", bs, w.headers)
+}
+
+// FileReporter appends every event as a json line
+type FileReporter struct {
+	file *os.File
+	mu   sync.Mutex
+}
+
+func NewFileReporter(path string) (*FileReporter, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileReporter{file: f}, nil
+}
+
+func (f *FileReporter) Report(event *PanicEvent) error {
+	bs, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err = f.file.Write(append(bs, '\n'))
+	return err
+}
+
+func (f *FileReporter) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
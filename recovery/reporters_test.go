@@ -0,0 +1,219 @@
+package recovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/linxlib/fw_middlewares/auth"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testEvent() *PanicEvent {
+	return &PanicEvent{
+		ID:          newEventID(),
+		Fingerprint: "0123456789abcdef",
+		Time:        time.Now(),
+		Message:     "boom",
+		Type:        "*errors.errorString",
+		Frames:      frames(0, 3),
+		Request: RequestSnapshot{
+			Method:  "GET",
+			URL:     "http://example.com/panic?id=1",
+			Path:    "/panic",
+			Query:   "id=1",
+			Headers: map[string]string{"Authorization": "[redacted]"},
+		},
+		Principal: &auth.Principal{ID: "alice", Method: auth.MethodBasic},
+	}
+}
+
+func TestSentryReporterEnvelope(t *testing.T) {
+	type received struct {
+		path, auth, contentType string
+		body                    []byte
+	}
+	requests := make(chan received, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{r.URL.Path, r.Header.Get("X-Sentry-Auth"), r.Header.Get("Content-Type"), body}
+	}))
+	defer server.Close()
+
+	dsn := strings.Replace(server.URL, "://", "://public@", 1) + "/42"
+	reporter, err := NewSentryReporter(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := testEvent()
+	if err = reporter.Report(event); err != nil {
+		t.Fatal(err)
+	}
+	got := <-requests
+
+	if got.path != "/api/42/envelope/" {
+		t.Errorf("path %s", got.path)
+	}
+	if !strings.HasPrefix(got.auth, "Sentry sentry_version=7") || !strings.Contains(got.auth, "sentry_key=public") {
+		t.Errorf("X-Sentry-Auth %q", got.auth)
+	}
+	if got.contentType != "application/x-sentry-envelope" {
+		t.Errorf("content type %s", got.contentType)
+	}
+
+	lines := bytes.Split(bytes.TrimSuffix(got.body, []byte("\n")), []byte("\n"))
+	if len(lines) != 3 {
+		t.Fatalf("envelope has %d lines:\n%s", len(lines), got.body)
+	}
+	var header struct {
+		EventID string `json:"event_id"`
+		DSN     string `json:"dsn"`
+		SentAt  string `json:"sent_at"`
+	}
+	if err = json.Unmarshal(lines[0], &header); err != nil {
+		t.Fatal(err)
+	}
+	if header.EventID != event.ID || header.DSN != dsn || header.SentAt == "" {
+		t.Errorf("envelope header %+v", header)
+	}
+	var item struct {
+		Type   string `json:"type"`
+		Length int    `json:"length"`
+	}
+	if err = json.Unmarshal(lines[1], &item); err != nil {
+		t.Fatal(err)
+	}
+	if item.Type != "event" || item.Length != len(lines[2]) {
+		t.Errorf("item header %+v, payload is %d bytes", item, len(lines[2]))
+	}
+	var payload struct {
+		EventID     string   `json:"event_id"`
+		Fingerprint []string `json:"fingerprint"`
+		Exception   struct {
+			Values []struct {
+				Type       string `json:"type"`
+				Value      string `json:"value"`
+				Stacktrace struct {
+					Frames []sentryFrame `json:"frames"`
+				} `json:"stacktrace"`
+			} `json:"values"`
+		} `json:"exception"`
+		Request struct {
+			URL     string            `json:"url"`
+			Headers map[string]string `json:"headers"`
+		} `json:"request"`
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	}
+	if err = json.Unmarshal(lines[2], &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.EventID != event.ID || len(payload.Fingerprint) != 1 || payload.Fingerprint[0] != event.Fingerprint {
+		t.Errorf("event id %s fingerprint %v", payload.EventID, payload.Fingerprint)
+	}
+	if len(payload.Exception.Values) != 1 || payload.Exception.Values[0].Value != "boom" {
+		t.Fatalf("exception %+v", payload.Exception)
+	}
+	sentFrames := payload.Exception.Values[0].Stacktrace.Frames
+	if len(sentFrames) != len(event.Frames) || sentFrames[len(sentFrames)-1].Lineno != event.Frames[0].Line {
+		t.Errorf("frames are not outermost first")
+	}
+	if payload.Request.URL != event.Request.URL || payload.Request.Headers["Authorization"] != "[redacted]" {
+		t.Errorf("request %+v", payload.Request)
+	}
+	if payload.User.ID != "alice" {
+		t.Errorf("user %+v", payload.User)
+	}
+}
+
+func TestSentryReporterErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	reporter, err := NewSentryReporter(strings.Replace(server.URL, "://", "://public@", 1) + "/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = reporter.Report(testEvent()); err == nil {
+		t.Error("a 429 is not an error")
+	}
+}
+
+func TestNewSentryReporterRejectsBadDsn(t *testing.T) {
+	for _, dsn := range []string{"http://127.0.0.1/1", "http://key@127.0.0.1/", "::"} {
+		if _, err := NewSentryReporter(dsn); err == nil {
+			t.Errorf("%q accepted", dsn)
+		}
+	}
+}
+
+func TestAsyncReporterDrainsOnClose(t *testing.T) {
+	var reported atomic.Int64
+	slow := PanicReporterFunc(func(event *PanicEvent) error {
+		time.Sleep(5 * time.Millisecond)
+		reported.Add(1)
+		return nil
+	})
+	file := filepath.Join(t.TempDir(), "panics.jsonl")
+	fileReporter, err := NewFileReporter(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewAsyncReporter(600, slow, fileReporter)
+	for i := 0; i < 10; i++ {
+		_ = r.Report(testEvent())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = r.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := reported.Load(); n != 10 {
+		t.Errorf("%d of 10 events reported before Close returned", n)
+	}
+	bs, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(bs, []byte("\n")); n != 10 {
+		t.Errorf("file has %d lines", n)
+	}
+	// the file reporter is closed with the queue
+	if err = fileReporter.Report(testEvent()); err == nil {
+		t.Error("file reporter still open")
+	}
+	_ = r.Report(testEvent())
+	if r.Dropped() != 1 {
+		t.Errorf("event after Close not dropped, dropped %d", r.Dropped())
+	}
+}
+
+func TestAsyncReporterOnError(t *testing.T) {
+	errs := make(chan error, 1)
+	r := NewAsyncReporter(0, PanicReporterFunc(func(event *PanicEvent) error {
+		return errors.New("sentry down")
+	}))
+	r.OnError(func(err error) {
+		errs <- err
+	})
+	_ = r.Report(testEvent())
+	select {
+	case err := <-errs:
+		if err.Error() != "sentry down" {
+			t.Errorf("got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnError not called")
+	}
+	_ = r.Close(context.Background())
+}
@@ -0,0 +1,195 @@
+package session
+
+import (
+	"github.com/linxlib/conv"
+	"github.com/linxlib/fw"
+	"github.com/valyala/fasthttp"
+	"strings"
+	"time"
+)
+
+var _ fw.IMiddlewareGlobal = (*SessionMiddleware)(nil)
+
+const (
+	sessionName = "Session"
+
+	// SessionKey is the context key of *Session
+	SessionKey = "session"
+
+	// last access is only refreshed with this granularity, so idle sessions are not saved on every request
+	touchInterval = time.Minute
+)
+
+type SessionOption struct {
+	Store           string `yaml:"store" default:"memory"`        // memory, file or cookie
+	Dir             string `yaml:"dir" default:"./data/sessions"` // directory of the file store
+	Secret          string `yaml:"secret" default:""`             // cookie store secret, at least 32 bytes
+	Encrypt         bool   `yaml:"encrypt" default:"false"`       // encrypt the cookie store instead of only signing it
+	CookieName      string `yaml:"cookie_name" default:"fw_session"`
+	Path            string `yaml:"path" default:"/"`
+	Domain          string `yaml:"domain" default:""`
+	Secure          bool   `yaml:"secure" default:"false"`
+	HttpOnly        bool   `yaml:"http_only" default:"true"`
+	SameSite        string `yaml:"same_site" default:"lax"`        // lax, strict or none
+	IdleTimeout     string `yaml:"idle_timeout" default:"30m"`     // session expires after no request for this long
+	AbsoluteTimeout string `yaml:"absolute_timeout" default:"24h"` // session expires this long after creation regardless of activity
+}
+
+// SessionMiddleware loads the session of the request and maps *Session into the context.
+type SessionMiddleware struct {
+	*fw.MiddlewareGlobal
+	options  *SessionOption
+	store    Store
+	idle     time.Duration
+	absolute time.Duration
+}
+
+func (s *SessionMiddleware) DoInitOnce() {
+	s.LoadConfig("session", s.options)
+	var err error
+	if s.idle, err = time.ParseDuration(s.options.IdleTimeout); err != nil {
+		panic("session: invalid idle_timeout: " + err.Error())
+	}
+	if s.absolute, err = time.ParseDuration(s.options.AbsoluteTimeout); err != nil {
+		panic("session: invalid absolute_timeout: " + err.Error())
+	}
+	if s.store != nil {
+		return
+	}
+	switch strings.ToLower(s.options.Store) {
+	case "file":
+		s.store, err = NewFileStore(s.options.Dir)
+	case "cookie":
+		s.store, err = NewCookieStore(s.options.Secret, s.options.Encrypt)
+	default:
+		s.store = NewMemoryStore()
+	}
+	if err != nil {
+		panic("session: " + err.Error())
+	}
+}
+
+// expired checks the idle and absolute timeouts
+func (s *SessionMiddleware) expired(sess *Session, now time.Time) bool {
+	if s.idle > 0 && now.Sub(sess.data.LastAccess) > s.idle {
+		return true
+	}
+	if s.absolute > 0 && now.Sub(sess.data.CreatedAt) > s.absolute {
+		return true
+	}
+	return false
+}
+
+func (s *SessionMiddleware) deadline(sess *Session) time.Time {
+	var deadline time.Time
+	if s.absolute > 0 {
+		deadline = sess.data.CreatedAt.Add(s.absolute)
+	}
+	if s.idle > 0 {
+		if idle := sess.data.LastAccess.Add(s.idle); deadline.IsZero() || idle.Before(deadline) {
+			deadline = idle
+		}
+	}
+	if deadline.IsZero() {
+		// no timeout configured, still do not keep sessions forever in server stores
+		deadline = sess.data.LastAccess.Add(30 * 24 * time.Hour)
+	}
+	return deadline
+}
+
+func (s *SessionMiddleware) setCookie(fctx *fasthttp.RequestCtx, value string, remove bool) {
+	cookie := fasthttp.AcquireCookie()
+	defer fasthttp.ReleaseCookie(cookie)
+	cookie.SetKey(s.options.CookieName)
+	cookie.SetValue(value)
+	cookie.SetPath(s.options.Path)
+	cookie.SetDomain(s.options.Domain)
+	cookie.SetSecure(s.options.Secure)
+	cookie.SetHTTPOnly(s.options.HttpOnly)
+	switch strings.ToLower(s.options.SameSite) {
+	case "strict":
+		cookie.SetSameSite(fasthttp.CookieSameSiteStrictMode)
+	case "none":
+		cookie.SetSameSite(fasthttp.CookieSameSiteNoneMode)
+	default:
+		cookie.SetSameSite(fasthttp.CookieSameSiteLaxMode)
+	}
+	if remove {
+		cookie.SetExpire(fasthttp.CookieExpireDelete)
+	}
+	fctx.Response.Header.SetCookie(cookie)
+}
+
+func (s *SessionMiddleware) load(fctx *fasthttp.RequestCtx, now time.Time) *Session {
+	value := conv.String(fctx.Request.Header.Cookie(s.options.CookieName))
+	if value == "" {
+		return newSession()
+	}
+	sess, err := s.store.Load(value)
+	if err != nil || sess == nil {
+		return newSession()
+	}
+	if s.expired(sess, now) {
+		_ = s.store.Delete(sess)
+		return newSession()
+	}
+	return sess
+}
+
+func (s *SessionMiddleware) Execute(ctx *fw.MiddlewareContext) fw.HandlerFunc {
+	return func(context *fw.Context) {
+		fctx := context.GetFastContext()
+		now := time.Now()
+		sess := s.load(fctx, now)
+		if !sess.isNew && now.Sub(sess.data.LastAccess) > touchInterval {
+			sess.data.LastAccess = now
+			sess.changed = true
+		}
+		context.Set(SessionKey, sess)
+		context.Map(sess)
+
+		ctx.Next(context)
+
+		if sess.destroyed {
+			if !sess.isNew || sess.oldID != "" {
+				_ = s.store.Delete(sess)
+				s.setCookie(fctx, "", true)
+			}
+			return
+		}
+		// do not create empty sessions for every anonymous request
+		if !sess.changed || (sess.isNew && len(sess.data.Values) == 0 && len(sess.data.Flashes) == 0) {
+			return
+		}
+		sess.expires = s.deadline(sess)
+		value, err := s.store.Save(sess)
+		if err != nil {
+			context.Set("fw_err", err)
+			return
+		}
+		s.setCookie(fctx, value, false)
+	}
+}
+
+// NewSessionMiddleware creates the middleware configured by the session section,
+// a custom store can be given instead.
+func NewSessionMiddleware(store ...Store) fw.IMiddlewareGlobal {
+	s := &SessionMiddleware{
+		MiddlewareGlobal: fw.NewMiddlewareGlobal(sessionName),
+		options:          new(SessionOption),
+	}
+	if len(store) > 0 {
+		s.store = store[0]
+	}
+	return s
+}
+
+// FromContext returns the session of the request, or nil when SessionMiddleware is not used
+func FromContext(context *fw.Context) *Session {
+	if v, ok := context.Get(SessionKey); ok {
+		if sess, ok := v.(*Session); ok {
+			return sess
+		}
+	}
+	return nil
+}
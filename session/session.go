@@ -0,0 +1,148 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+// data is the serialized part of a session.
+// values are stored as json, so numbers come back as float64
+type data struct {
+	Values     map[string]any `json:"v,omitempty"`
+	Flashes    []any          `json:"f,omitempty"`
+	CreatedAt  time.Time      `json:"c"`
+	LastAccess time.Time      `json:"a"`
+}
+
+// Session is mapped into fw.Context by SessionMiddleware
+type Session struct {
+	id    string
+	oldID string
+	data  data
+
+	mu        sync.RWMutex
+	isNew     bool
+	changed   bool
+	destroyed bool
+	expires   time.Time
+}
+
+func newID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func newSession() *Session {
+	now := time.Now()
+	return &Session{
+		id:    newID(),
+		isNew: true,
+		data: data{
+			Values:     make(map[string]any),
+			CreatedAt:  now,
+			LastAccess: now,
+		},
+	}
+}
+
+// ID returns the session id, it is not used by CookieStore
+func (s *Session) ID() string {
+	return s.id
+}
+
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+func (s *Session) CreatedAt() time.Time {
+	return s.data.CreatedAt
+}
+
+// Expires returns the time the session expires by idle or absolute timeout
+func (s *Session) Expires() time.Time {
+	return s.expires
+}
+
+func (s *Session) Get(key string) (any, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.data.Values[key]
+	return v, ok
+}
+
+func (s *Session) GetString(key string) string {
+	v, _ := s.Get(key)
+	str, _ := v.(string)
+	return str
+}
+
+func (s *Session) Set(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Values == nil {
+		s.data.Values = make(map[string]any)
+	}
+	s.data.Values[key] = value
+	s.changed = true
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data.Values, key)
+	s.changed = true
+}
+
+// Clear removes all values and flashes but keeps the session
+func (s *Session) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Values = make(map[string]any)
+	s.data.Flashes = nil
+	s.changed = true
+}
+
+// AddFlash adds a message which will be removed once read by Flashes
+func (s *Session) AddFlash(value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Flashes = append(s.data.Flashes, value)
+	s.changed = true
+}
+
+// Flashes returns and clears all flash messages
+func (s *Session) Flashes() []any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flashes := s.data.Flashes
+	if len(flashes) > 0 {
+		s.data.Flashes = nil
+		s.changed = true
+	}
+	return flashes
+}
+
+// RenewID gives the session a new id and keeps its values.
+// call it after login to prevent session fixation.
+func (s *Session) RenewID() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.oldID == "" && !s.isNew {
+		s.oldID = s.id
+	}
+	s.id = newID()
+	s.changed = true
+}
+
+// Destroy removes the session from the store and expires the cookie
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+	s.changed = true
+}
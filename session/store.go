@@ -0,0 +1,349 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrCookieTooLarge = errors.New("session cookie exceeds 4096 bytes")
+
+// Store loads and saves sessions.
+// server side stores use the session id as cookie value,
+// CookieStore puts the whole session into the cookie.
+type Store interface {
+	// Load returns the session of the cookie value, or nil when it does not exist or is invalid
+	Load(value string) (*Session, error)
+	// Save persists the session and returns the cookie value
+	Save(s *Session) (string, error)
+	Delete(s *Session) error
+}
+
+type record struct {
+	Expires time.Time `json:"e"`
+	Data    data      `json:"d"`
+}
+
+// janitor removes expired sessions of a server side store every interval until it is closed
+type janitor struct {
+	stop chan struct{}
+	once sync.Once
+}
+
+func newJanitor(interval time.Duration, sweep func(now time.Time)) *janitor {
+	j := &janitor{stop: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				sweep(now)
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+	return j
+}
+
+// Close stops removing expired sessions, the store can still be used
+func (j *janitor) Close() error {
+	j.once.Do(func() { close(j.stop) })
+	return nil
+}
+
+func loaded(id string, d data, expires time.Time) *Session {
+	if d.Values == nil {
+		d.Values = make(map[string]any)
+	}
+	return &Session{id: id, data: d, expires: expires}
+}
+
+// MemoryStore keeps sessions in process memory, they are lost on restart.
+// Close stops the removal of expired sessions.
+type MemoryStore struct {
+	*janitor
+	mu       sync.RWMutex
+	sessions map[string]record
+}
+
+func NewMemoryStore() *MemoryStore {
+	m := &MemoryStore{sessions: make(map[string]record)}
+	m.janitor = newJanitor(time.Minute, m.gc)
+	return m
+}
+
+func (m *MemoryStore) gc(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, r := range m.sessions {
+		if now.After(r.Expires) {
+			delete(m.sessions, id)
+		}
+	}
+}
+
+func (m *MemoryStore) Load(value string) (*Session, error) {
+	m.mu.RLock()
+	r, ok := m.sessions[value]
+	m.mu.RUnlock()
+	if !ok || time.Now().After(r.Expires) {
+		return nil, nil
+	}
+	// copy values, the map in the store must not be shared with the request
+	d := r.Data
+	d.Values = make(map[string]any, len(r.Data.Values))
+	for k, v := range r.Data.Values {
+		d.Values[k] = v
+	}
+	d.Flashes = append([]any(nil), r.Data.Flashes...)
+	return loaded(value, d, r.Expires), nil
+}
+
+func (m *MemoryStore) Save(s *Session) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d := s.data
+	d.Values = make(map[string]any, len(s.data.Values))
+	for k, v := range s.data.Values {
+		d.Values[k] = v
+	}
+	d.Flashes = append([]any(nil), s.data.Flashes...)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s.oldID != "" {
+		delete(m.sessions, s.oldID)
+	}
+	m.sessions[s.id] = record{Expires: s.expires, Data: d}
+	return s.id, nil
+}
+
+func (m *MemoryStore) Delete(s *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, s.id)
+	if s.oldID != "" {
+		delete(m.sessions, s.oldID)
+	}
+	return nil
+}
+
+// FileStore keeps every session in a json file under dir.
+// Close stops the removal of expired sessions.
+type FileStore struct {
+	*janitor
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	f := &FileStore{dir: dir}
+	f.janitor = newJanitor(10*time.Minute, f.gc)
+	return f, nil
+}
+
+// file names are hashed so a forged cookie can never point outside dir
+func (f *FileStore) file(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(f.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (f *FileStore) gc(now time.Time) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		name := filepath.Join(f.dir, entry.Name())
+		r, err := readRecord(name)
+		if err != nil || now.After(r.Expires) {
+			_ = os.Remove(name)
+		}
+	}
+}
+
+func readRecord(name string) (*record, error) {
+	bs, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	r := new(record)
+	if err = json.Unmarshal(bs, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (f *FileStore) Load(value string) (*Session, error) {
+	if value == "" {
+		return nil, nil
+	}
+	name := f.file(value)
+	r, err := readRecord(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if time.Now().After(r.Expires) {
+		_ = os.Remove(name)
+		return nil, nil
+	}
+	return loaded(value, r.Data, r.Expires), nil
+}
+
+func (f *FileStore) Save(s *Session) (string, error) {
+	s.mu.RLock()
+	bs, err := json.Marshal(record{Expires: s.expires, Data: s.data})
+	s.mu.RUnlock()
+	if err != nil {
+		return "", err
+	}
+	name := f.file(s.id)
+	tmp := name + ".tmp"
+	if err = os.WriteFile(tmp, bs, 0o600); err != nil {
+		return "", err
+	}
+	if err = os.Rename(tmp, name); err != nil {
+		return "", err
+	}
+	if s.oldID != "" {
+		_ = os.Remove(f.file(s.oldID))
+	}
+	return s.id, nil
+}
+
+func (f *FileStore) Delete(s *Session) error {
+	if s.oldID != "" {
+		_ = os.Remove(f.file(s.oldID))
+	}
+	err := os.Remove(f.file(s.id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// CookieStore keeps the whole session in the cookie, signed with HMAC-SHA256
+// or, when encrypt is true, encrypted with AES-256-GCM.
+type CookieStore struct {
+	signKey []byte
+	aead    cipher.AEAD
+}
+
+func deriveKey(secret string, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func NewCookieStore(secret string, encrypt bool) (*CookieStore, error) {
+	if len(secret) < 32 {
+		return nil, errors.New("session secret must be at least 32 bytes")
+	}
+	c := &CookieStore{signKey: deriveKey(secret, "fw-session-sign")}
+	if encrypt {
+		block, err := aes.NewCipher(deriveKey(secret, "fw-session-encrypt"))
+		if err != nil {
+			return nil, err
+		}
+		if c.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *CookieStore) sign(payload string) string {
+	mac := hmac.New(sha256.New, c.signKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (c *CookieStore) decode(value string) ([]byte, bool) {
+	if c.aead != nil {
+		bs, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil || len(bs) < c.aead.NonceSize() {
+			return nil, false
+		}
+		nonce, ciphertext := bs[:c.aead.NonceSize()], bs[c.aead.NonceSize():]
+		plain, err := c.aead.Open(nil, nonce, ciphertext, nil)
+		return plain, err == nil
+	}
+	payload, sig, found := strings.Cut(value, ".")
+	if !found || !hmac.Equal([]byte(sig), []byte(c.sign(payload))) {
+		return nil, false
+	}
+	bs, err := base64.RawURLEncoding.DecodeString(payload)
+	return bs, err == nil
+}
+
+func (c *CookieStore) encode(plain []byte) (string, error) {
+	if c.aead != nil {
+		nonce := make([]byte, c.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		return base64.RawURLEncoding.EncodeToString(c.aead.Seal(nonce, nonce, plain, nil)), nil
+	}
+	payload := base64.RawURLEncoding.EncodeToString(plain)
+	return payload + "." + c.sign(payload), nil
+}
+
+func (c *CookieStore) Load(value string) (*Session, error) {
+	if value == "" {
+		return nil, nil
+	}
+	plain, ok := c.decode(value)
+	if !ok {
+		// tampered or signed with another secret
+		return nil, nil
+	}
+	r := new(record)
+	if err := json.Unmarshal(plain, r); err != nil {
+		return nil, nil
+	}
+	if time.Now().After(r.Expires) {
+		return nil, nil
+	}
+	return loaded("", r.Data, r.Expires), nil
+}
+
+func (c *CookieStore) Save(s *Session) (string, error) {
+	s.mu.RLock()
+	bs, err := json.Marshal(record{Expires: s.expires, Data: s.data})
+	s.mu.RUnlock()
+	if err != nil {
+		return "", err
+	}
+	value, err := c.encode(bs)
+	if err != nil {
+		return "", err
+	}
+	if len(value) > 4096 {
+		return "", ErrCookieTooLarge
+	}
+	return value, nil
+}
+
+func (c *CookieStore) Delete(s *Session) error {
+	return nil
+}
@@ -0,0 +1,193 @@
+package session
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func savedSession(t *testing.T, store Store) (*Session, string) {
+	t.Helper()
+	sess := newSession()
+	sess.Set("user", "alice")
+	sess.expires = time.Now().Add(time.Hour)
+	value, err := store.Save(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sess, value
+}
+
+func TestCookieStoreRejectsTampering(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		store, err := NewCookieStore(testSecret, encrypt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, value := savedSession(t, store)
+		if sess, _ := store.Load(value); sess == nil || sess.GetString("user") != "alice" {
+			t.Fatalf("encrypt %v: saved session not loaded", encrypt)
+		}
+		// flip a character in the middle of the payload, and one of the signature or tag at the end
+		for _, i := range []int{len(value) / 2, len(value) - 2} {
+			b := []byte(value)
+			if b[i] == 'A' {
+				b[i] = 'B'
+			} else {
+				b[i] = 'A'
+			}
+			if sess, _ := store.Load(string(b)); sess != nil {
+				t.Errorf("encrypt %v: tampered cookie loaded, byte %d", encrypt, i)
+			}
+		}
+		if sess, _ := store.Load(value[:len(value)/2]); sess != nil {
+			t.Errorf("encrypt %v: truncated cookie loaded", encrypt)
+		}
+		other, err := NewCookieStore(strings.Repeat("x", 32), encrypt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sess, _ := other.Load(value); sess != nil {
+			t.Errorf("encrypt %v: cookie of another secret loaded", encrypt)
+		}
+	}
+}
+
+func TestCookieStoreEncrypts(t *testing.T) {
+	store, err := NewCookieStore(testSecret, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, value := savedSession(t, store)
+	signed, err := NewCookieStore(testSecret, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an encrypted cookie is not accepted as a signed one, and the reverse
+	if sess, _ := signed.Load(value); sess != nil {
+		t.Fatal("encrypted cookie loaded by the signing store")
+	}
+	_, plain := savedSession(t, signed)
+	if sess, _ := store.Load(plain); sess != nil {
+		t.Fatal("signed cookie loaded by the encrypting store")
+	}
+}
+
+func TestCookieStoreExpires(t *testing.T) {
+	store, err := NewCookieStore(testSecret, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess := newSession()
+	sess.Set("user", "alice")
+	sess.expires = time.Now().Add(-time.Second)
+	value, err := store.Save(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded, _ := store.Load(value); loaded != nil {
+		t.Fatal("expired cookie loaded")
+	}
+}
+
+func TestRenewIDRemovesOldSession(t *testing.T) {
+	file, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	memory := NewMemoryStore()
+	defer memory.Close()
+	for name, store := range map[string]Store{"memory": memory, "file": file} {
+		_, old := savedSession(t, store)
+		sess, err := store.Load(old)
+		if err != nil || sess == nil {
+			t.Fatalf("%s: saved session not loaded: %v", name, err)
+		}
+		sess.RenewID()
+		if sess.ID() == old {
+			t.Fatalf("%s: id not renewed", name)
+		}
+		value, err := store.Save(sess)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded, _ := store.Load(old); loaded != nil {
+			t.Errorf("%s: session still loads with the old id", name)
+		}
+		if loaded, _ := store.Load(value); loaded == nil || loaded.GetString("user") != "alice" {
+			t.Errorf("%s: values lost with the new id", name)
+		}
+		// renewed twice in one request, the id the cookie had is still removed
+		sess, _ = store.Load(value)
+		sess.RenewID()
+		sess.RenewID()
+		if _, err = store.Save(sess); err != nil {
+			t.Fatal(err)
+		}
+		if loaded, _ := store.Load(value); loaded != nil {
+			t.Errorf("%s: session still loads with the replaced id", name)
+		}
+	}
+}
+
+func TestStoreGC(t *testing.T) {
+	file, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	memory := NewMemoryStore()
+	defer memory.Close()
+	for name, store := range map[string]interface {
+		Store
+		gc(now time.Time)
+	}{"memory": memory, "file": file} {
+		_, value := savedSession(t, store)
+		store.gc(time.Now())
+		if sess, _ := store.Load(value); sess == nil {
+			t.Fatalf("%s: live session removed", name)
+		}
+		store.gc(time.Now().Add(2 * time.Hour))
+		// Load hides expired sessions anyway, look into the store
+		switch s := store.(type) {
+		case *MemoryStore:
+			if len(s.sessions) != 0 {
+				t.Errorf("memory: %d expired sessions kept", len(s.sessions))
+			}
+		case *FileStore:
+			if _, err := readRecord(s.file(value)); err == nil {
+				t.Error("file: expired session kept")
+			}
+		}
+	}
+}
+
+func TestJanitorClose(t *testing.T) {
+	var sweeps atomic.Int32
+	j := newJanitor(time.Millisecond, func(time.Time) { sweeps.Add(1) })
+	deadline := time.Now().Add(time.Second)
+	for sweeps.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if sweeps.Load() == 0 {
+		t.Fatal("janitor never swept")
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// a sweep may be running while Close is called
+	time.Sleep(5 * time.Millisecond)
+	n := sweeps.Load()
+	time.Sleep(20 * time.Millisecond)
+	if got := sweeps.Load(); got != n {
+		t.Fatalf("%d sweeps after Close", got-n)
+	}
+	// closing twice is fine
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
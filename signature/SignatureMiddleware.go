@@ -0,0 +1,260 @@
+package signature
+
+import (
+	"errors"
+	"github.com/linxlib/conv"
+	"github.com/linxlib/fw"
+	"github.com/linxlib/fw_middlewares/auth"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var _ fw.IMiddlewareCtl = (*SignatureMiddleware)(nil)
+
+const (
+	signatureAttr = "Signature"
+	signatureName = "Signature"
+
+	// KeyIDKey is the context key of the key id which signed the request
+	KeyIDKey = "signature_key_id"
+
+	SchemeCanonical = "canonical"
+	SchemeGithub    = "github"
+	SchemeStripe    = "stripe"
+)
+
+type SignatureOption struct {
+	// key id => active secrets, list more than one secret while rotating
+	Secrets         map[string][]string `yaml:"secrets"`
+	SignedHeaders   []string            `yaml:"signed_headers"` // headers included in canonical requests, host and content-type when empty
+	Tolerance       string              `yaml:"tolerance" default:"5m"`
+	KeyIDHeader     string              `yaml:"key_id_header" default:"X-Key-Id"`
+	TimestampHeader string              `yaml:"timestamp_header" default:"X-Timestamp"`
+	NonceHeader     string              `yaml:"nonce_header" default:"X-Nonce"`
+	SignatureHeader string              `yaml:"signature_header" default:"X-Signature"`
+	NonceCacheSize  int                 `yaml:"nonce_cache_size" default:"100000"` // nonces remembered inside the window, requests get 503 when it is full
+}
+
+// SecretFunc returns the active secrets of a key id, nil when unknown
+type SecretFunc func(keyID string) []string
+
+// SignatureMiddleware verifies HMAC-SHA256 signed requests from partners and webhooks.
+// can be used on Controller
+//
+//	// @Signature                                  canonical request signed with X-Key-Id/X-Timestamp/X-Nonce/X-Signature
+//	// @Signature scheme=github key_id=github      X-Hub-Signature-256: sha256=hex(hmac(body)), no signed timestamp, see verifyGithub
+//	// @Signature scheme=stripe key_id=stripe      Stripe-Signature: t=timestamp,v1=hex(hmac(t.body))
+type SignatureMiddleware struct {
+	*fw.MiddlewareCtl
+	options   *SignatureOption
+	secrets   SecretFunc
+	tolerance time.Duration
+	nonces    *nonceCache
+}
+
+func (s *SignatureMiddleware) DoInitOnce() {
+	s.LoadConfig("signature", s.options)
+	if len(s.options.SignedHeaders) == 0 {
+		s.options.SignedHeaders = []string{"host", "content-type"}
+	}
+	d, err := time.ParseDuration(s.options.Tolerance)
+	if err != nil {
+		panic("signature: invalid tolerance: " + err.Error())
+	}
+	s.tolerance = d
+	if s.options.NonceCacheSize <= 0 {
+		// every signed request needs room in the cache
+		panic("signature: nonce_cache_size must be positive")
+	}
+	s.nonces = newNonceCache(s.options.NonceCacheSize)
+	if s.secrets == nil {
+		s.secrets = func(keyID string) []string {
+			return s.options.Secrets[keyID]
+		}
+	}
+}
+
+// rejection is why a request is rejected and the status it is answered with
+type rejection struct {
+	status int
+	reason string
+}
+
+func (r *rejection) Error() string {
+	return "signature: " + r.reason
+}
+
+func unauthorized(reason string) error {
+	return &rejection{status: http.StatusUnauthorized, reason: reason}
+}
+
+// replayed turns an error of the nonce cache into a rejection, a full cache is not the client's fault
+func replayed(err error, reason string) error {
+	if errors.Is(err, errNonceCacheFull) {
+		return &rejection{status: http.StatusServiceUnavailable, reason: err.Error()}
+	}
+	return unauthorized(reason)
+}
+
+// checkTimestamp parses unix seconds and checks it is inside the tolerance window
+func (s *SignatureMiddleware) checkTimestamp(ts string) (time.Time, bool) {
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	t := time.Unix(sec, 0)
+	d := time.Since(t)
+	if d < 0 {
+		d = -d
+	}
+	return t, d <= s.tolerance
+}
+
+func (s *SignatureMiddleware) Execute(ctx *fw.MiddlewareContext) fw.HandlerFunc {
+	scheme := ctx.GetParam("scheme")
+	fixedKeyID := ctx.GetParam("key_id")
+	headers := s.options.SignedHeaders
+	if v := ctx.GetParam("headers"); v != "" {
+		headers = strings.Split(v, ",")
+	}
+	verify := func(req *fasthttp.Request) (string, error) {
+		return s.verifyCanonical(req, fixedKeyID, headers)
+	}
+	switch scheme {
+	case SchemeGithub:
+		verify = func(req *fasthttp.Request) (string, error) {
+			return s.verifyGithub(req, fixedKeyID)
+		}
+	case SchemeStripe:
+		verify = func(req *fasthttp.Request) (string, error) {
+			return s.verifyStripe(req, fixedKeyID)
+		}
+	}
+	return func(context *fw.Context) {
+		keyID, err := verify(&context.GetFastContext().Request)
+		if err != nil {
+			status := http.StatusUnauthorized
+			var r *rejection
+			if errors.As(err, &r) {
+				status = r.status
+			}
+			context.JSON(status, fw.H{"error": err.Error()})
+			return
+		}
+		setKeyID(context, keyID)
+		ctx.Next(context)
+	}
+}
+
+// verifyCanonical checks a canonical request and returns the key id which signed it
+func (s *SignatureMiddleware) verifyCanonical(req *fasthttp.Request, fixedKeyID string, headers []string) (string, error) {
+	keyID := fixedKeyID
+	if keyID == "" {
+		keyID = conv.String(req.Header.Peek(s.options.KeyIDHeader))
+	}
+	ts := conv.String(req.Header.Peek(s.options.TimestampHeader))
+	nonce := conv.String(req.Header.Peek(s.options.NonceHeader))
+	sig, ok := decodeSignature(conv.String(req.Header.Peek(s.options.SignatureHeader)))
+	if keyID == "" || ts == "" || nonce == "" || !ok {
+		return "", unauthorized("missing or malformed signature headers")
+	}
+	t, ok := s.checkTimestamp(ts)
+	if !ok {
+		return "", unauthorized("timestamp outside of window")
+	}
+	payload, err := CanonicalRequest(req, headers, ts, nonce)
+	if err != nil {
+		return "", unauthorized("malformed query")
+	}
+	secrets := s.secrets(keyID)
+	if len(secrets) == 0 || !matchAny(secrets, []byte(payload), sig) {
+		return "", unauthorized("invalid signature")
+	}
+	// only remember nonces of valid signatures, otherwise anyone could fill the cache
+	if err = s.nonces.add(keyID+":"+nonce, t.Add(s.tolerance)); err != nil {
+		return "", replayed(err, "nonce already used")
+	}
+	return keyID, nil
+}
+
+// verifyGithub checks X-Hub-Signature-256. github signs neither a timestamp nor the unsigned
+// X-GitHub-Delivery id, so a delivery is only refused again while its signature is remembered,
+// for the tolerance window. a captured body can be replayed after that, handlers of github events
+// must be idempotent.
+func (s *SignatureMiddleware) verifyGithub(req *fasthttp.Request, keyID string) (string, error) {
+	header := conv.String(req.Header.Peek("X-Hub-Signature-256"))
+	sig, ok := decodeSignature(header)
+	if !strings.HasPrefix(header, "sha256=") || !ok {
+		return "", unauthorized("missing or malformed X-Hub-Signature-256")
+	}
+	secrets := s.secrets(keyID)
+	if len(secrets) == 0 || !matchAny(secrets, req.Body(), sig) {
+		return "", unauthorized("invalid signature")
+	}
+	// the signature covers the body only, a new delivery id does not make a replay new
+	if err := s.nonces.add(keyID+":"+string(sig), time.Now().Add(s.tolerance)); err != nil {
+		return "", replayed(err, "delivery already processed")
+	}
+	return keyID, nil
+}
+
+// verifyStripe checks Stripe-Signature, its signed timestamp bounds replays to the tolerance window
+func (s *SignatureMiddleware) verifyStripe(req *fasthttp.Request, keyID string) (string, error) {
+	var ts string
+	var sigs [][]byte
+	for _, part := range strings.Split(conv.String(req.Header.Peek("Stripe-Signature")), ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			if sig, ok := decodeSignature(v); ok {
+				sigs = append(sigs, sig)
+			}
+		}
+	}
+	if ts == "" || len(sigs) == 0 {
+		return "", unauthorized("missing or malformed Stripe-Signature")
+	}
+	t, ok := s.checkTimestamp(ts)
+	if !ok {
+		return "", unauthorized("timestamp outside of window")
+	}
+	secrets := s.secrets(keyID)
+	payload := append([]byte(ts+"."), req.Body()...)
+	var matched []byte
+	for _, sig := range sigs {
+		if len(secrets) > 0 && matchAny(secrets, payload, sig) {
+			matched = sig
+			break
+		}
+	}
+	if matched == nil {
+		return "", unauthorized("invalid signature")
+	}
+	if err := s.nonces.add(keyID+":"+string(matched), t.Add(s.tolerance)); err != nil {
+		return "", replayed(err, "signature already used")
+	}
+	return keyID, nil
+}
+
+func setKeyID(context *fw.Context, keyID string) {
+	context.Set(KeyIDKey, keyID)
+	auth.SetPrincipal(context, &auth.Principal{ID: keyID, Method: auth.MethodSignature})
+}
+
+// NewSignatureMiddleware creates the middleware, secrets come from signature.secrets
+// unless a SecretFunc is given, e.g. to look them up in a database.
+func NewSignatureMiddleware(secrets ...SecretFunc) fw.IMiddlewareCtl {
+	s := &SignatureMiddleware{
+		MiddlewareCtl: fw.NewMiddlewareCtl(signatureName, signatureAttr),
+		options:       new(SignatureOption),
+	}
+	if len(secrets) > 0 {
+		s.secrets = secrets[0]
+	}
+	return s
+}
@@ -0,0 +1,164 @@
+package signature
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newSignature(secrets map[string][]string, cacheSize int) *SignatureMiddleware {
+	s := NewSignatureMiddleware(func(keyID string) []string {
+		return secrets[keyID]
+	}).(*SignatureMiddleware)
+	*s.options = SignatureOption{
+		KeyIDHeader:     "X-Key-Id",
+		TimestampHeader: "X-Timestamp",
+		NonceHeader:     "X-Nonce",
+		SignatureHeader: "X-Signature",
+	}
+	s.tolerance = 5 * time.Minute
+	s.nonces = newNonceCache(cacheSize)
+	return s
+}
+
+var signedHeaders = []string{"host", "content-type"}
+
+func signedRequest(t *testing.T, keyID, secret string, at time.Time, nonce string) *fasthttp.Request {
+	t.Helper()
+	req := &fasthttp.Request{}
+	req.Header.SetMethod("POST")
+	req.SetRequestURI("http://api.example.com/orders?b=2&a=1")
+	req.Header.SetContentType("application/json")
+	req.SetBodyString(`{"id":1}`)
+	ts := strconv.FormatInt(at.Unix(), 10)
+	payload, err := CanonicalRequest(req, signedHeaders, ts, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Key-Id", keyID)
+	req.Header.Set("X-Timestamp", ts)
+	req.Header.Set("X-Nonce", nonce)
+	req.Header.Set("X-Signature", hex.EncodeToString(sign(secret, []byte(payload))))
+	return req
+}
+
+// status is the status a rejection is answered with, 0 when the request is accepted
+func status(err error) int {
+	var r *rejection
+	if errors.As(err, &r) {
+		return r.status
+	}
+	if err != nil {
+		return -1
+	}
+	return 0
+}
+
+func TestVerifyCanonical(t *testing.T) {
+	s := newSignature(map[string][]string{"partner": {"new-secret", "old-secret"}}, 100)
+	now := time.Now()
+	cases := []struct {
+		name   string
+		req    *fasthttp.Request
+		status int
+	}{
+		{"current secret", signedRequest(t, "partner", "new-secret", now, "n1"), 0},
+		{"rotated out secret still listed", signedRequest(t, "partner", "old-secret", now, "n2"), 0},
+		{"unknown secret", signedRequest(t, "partner", "other-secret", now, "n3"), http.StatusUnauthorized},
+		{"unknown key id", signedRequest(t, "stranger", "new-secret", now, "n4"), http.StatusUnauthorized},
+		{"too old", signedRequest(t, "partner", "new-secret", now.Add(-6*time.Minute), "n5"), http.StatusUnauthorized},
+		{"too far ahead", signedRequest(t, "partner", "new-secret", now.Add(6*time.Minute), "n6"), http.StatusUnauthorized},
+		{"inside the window", signedRequest(t, "partner", "new-secret", now.Add(-4*time.Minute), "n7"), 0},
+	}
+	for _, c := range cases {
+		if got := status(verify(s, c.req)); got != c.status {
+			t.Errorf("%s: status %d, want %d", c.name, got, c.status)
+		}
+	}
+	tampered := signedRequest(t, "partner", "new-secret", now, "n8")
+	tampered.SetBodyString(`{"id":2}`)
+	if got := status(verify(s, tampered)); got != http.StatusUnauthorized {
+		t.Errorf("tampered body: status %d", got)
+	}
+}
+
+// verify checks a canonical request with the key id in its header
+func verify(s *SignatureMiddleware, req *fasthttp.Request) error {
+	_, err := s.verifyCanonical(req, "", signedHeaders)
+	return err
+}
+
+func TestVerifyCanonicalReplay(t *testing.T) {
+	s := newSignature(map[string][]string{"partner": {"secret"}}, 100)
+	req := signedRequest(t, "partner", "secret", time.Now(), "once")
+	if keyID, err := s.verifyCanonical(req, "", signedHeaders); err != nil || keyID != "partner" {
+		t.Fatalf("first request = %q, %v", keyID, err)
+	}
+	err := verify(s, req)
+	if status(err) != http.StatusUnauthorized || err.Error() != "signature: nonce already used" {
+		t.Fatalf("replay = %v", err)
+	}
+	// nonces are per key id, and only valid signatures take a place in the cache
+	forged := signedRequest(t, "partner", "wrong", time.Now(), "fresh")
+	if status(verify(s, forged)) != http.StatusUnauthorized {
+		t.Fatal("forged request accepted")
+	}
+	if err = verify(s, signedRequest(t, "partner", "secret", time.Now(), "fresh")); err != nil {
+		t.Fatalf("nonce of a forged request was remembered: %v", err)
+	}
+}
+
+func TestVerifyCanonicalCacheFull(t *testing.T) {
+	s := newSignature(map[string][]string{"partner": {"secret"}}, 1)
+	if err := verify(s, signedRequest(t, "partner", "secret", time.Now(), "a")); err != nil {
+		t.Fatal(err)
+	}
+	err := verify(s, signedRequest(t, "partner", "secret", time.Now(), "b"))
+	if status(err) != http.StatusServiceUnavailable {
+		t.Fatalf("full cache = %v, want 503", err)
+	}
+}
+
+func TestVerifyGithubReplayWithNewDelivery(t *testing.T) {
+	s := newSignature(map[string][]string{"github": {"secret"}}, 100)
+	body := []byte(`{"action":"opened"}`)
+	delivery := func(id string) *fasthttp.Request {
+		req := &fasthttp.Request{}
+		req.SetBody(body)
+		req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(sign("secret", body)))
+		req.Header.Set("X-GitHub-Delivery", id)
+		return req
+	}
+	if _, err := s.verifyGithub(delivery("1"), "github"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.verifyGithub(delivery("2"), "github"); status(err) != http.StatusUnauthorized {
+		t.Fatalf("replay with a new delivery id = %v", err)
+	}
+}
+
+func TestVerifyStripe(t *testing.T) {
+	s := newSignature(map[string][]string{"stripe": {"secret"}}, 100)
+	body := `{"type":"charge.succeeded"}`
+	event := func(at time.Time) *fasthttp.Request {
+		ts := strconv.FormatInt(at.Unix(), 10)
+		req := &fasthttp.Request{}
+		req.SetBodyString(body)
+		req.Header.Set("Stripe-Signature", "t="+ts+",v1="+hex.EncodeToString(sign("secret", []byte(ts+"."+body))))
+		return req
+	}
+	now := time.Now()
+	if _, err := s.verifyStripe(event(now), "stripe"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.verifyStripe(event(now), "stripe"); status(err) != http.StatusUnauthorized {
+		t.Fatalf("replay = %v", err)
+	}
+	if _, err := s.verifyStripe(event(now.Add(-10*time.Minute)), "stripe"); status(err) != http.StatusUnauthorized {
+		t.Fatalf("old event = %v", err)
+	}
+}
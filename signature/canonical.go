@@ -0,0 +1,161 @@
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/valyala/fasthttp"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CanonicalRequest builds the string signed in canonical scheme:
+//
+//	METHOD
+//	/escaped/path
+//	a=1&b=2            (query sorted by key then value)
+//	1700000000         (timestamp)
+//	nonce
+//	content-type:application/json
+//	host:api.example.com
+//	hex(sha256(body))
+//
+// clients must build the same string and send hex(hmac-sha256(secret, string)).
+// it returns ErrMalformedQuery when the query can not be parsed.
+func CanonicalRequest(req *fasthttp.Request, headers []string, timestamp string, nonce string) (string, error) {
+	query, err := canonicalQuery(req.URI().QueryString())
+	if err != nil {
+		return "", err
+	}
+	b := &strings.Builder{}
+	b.Write(req.Header.Method())
+	b.WriteByte('\n')
+	b.Write(req.URI().PathOriginal())
+	b.WriteByte('\n')
+	b.WriteString(query)
+	b.WriteByte('\n')
+	b.WriteString(timestamp)
+	b.WriteByte('\n')
+	b.WriteString(nonce)
+	b.WriteByte('\n')
+	for _, h := range headers {
+		h = strings.ToLower(strings.TrimSpace(h))
+		b.WriteString(h)
+		b.WriteByte(':')
+		if h == "host" {
+			b.Write(req.Host())
+		} else {
+			b.WriteString(strings.TrimSpace(string(req.Header.Peek(h))))
+		}
+		b.WriteByte('\n')
+	}
+	sum := sha256.Sum256(req.Body())
+	b.WriteString(hex.EncodeToString(sum[:]))
+	return b.String(), nil
+}
+
+// ErrMalformedQuery is returned for queries url.ParseQuery rejects. they must not be signed as an empty
+// query, fasthttp still hands the parameters it can parse to the handler.
+var ErrMalformedQuery = errors.New("signature: malformed query")
+
+func canonicalQuery(raw []byte) (string, error) {
+	values, err := url.ParseQuery(string(raw))
+	if err != nil {
+		return "", ErrMalformedQuery
+	}
+	if len(values) == 0 {
+		return "", nil
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(values))
+	for _, k := range keys {
+		vs := values[k]
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+	}
+	return strings.Join(parts, "&"), nil
+}
+
+func sign(secret string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// decodeSignature accepts hex or base64, with an optional "sha256=" prefix
+func decodeSignature(s string) ([]byte, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "sha256=")
+	if bs, err := hex.DecodeString(s); err == nil && len(bs) == sha256.Size {
+		return bs, true
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if bs, err := enc.DecodeString(s); err == nil && len(bs) == sha256.Size {
+			return bs, true
+		}
+	}
+	return nil, false
+}
+
+// matchAny compares sig with the signature of every active secret, so secrets can be rotated
+func matchAny(secrets []string, payload []byte, sig []byte) bool {
+	ok := false
+	for _, secret := range secrets {
+		if hmac.Equal(sign(secret, payload), sig) {
+			ok = true
+		}
+	}
+	return ok
+}
+
+var (
+	errNonceUsed      = errors.New("nonce already used")
+	errNonceCacheFull = errors.New("replay cache full")
+)
+
+// nonceCache remembers seen nonces until they are outside the timestamp window
+type nonceCache struct {
+	mu      sync.Mutex
+	seen    map[string]time.Time
+	max     int
+	counter int
+}
+
+func newNonceCache(max int) *nonceCache {
+	return &nonceCache{seen: make(map[string]time.Time), max: max}
+}
+
+// add returns errNonceUsed when the nonce was already used and errNonceCacheFull when
+// max nonces inside the window are remembered
+func (n *nonceCache) add(nonce string, expires time.Time) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := time.Now()
+	if exp, ok := n.seen[nonce]; ok && now.Before(exp) {
+		return errNonceUsed
+	}
+	n.counter++
+	if n.counter%1000 == 0 || len(n.seen) >= n.max {
+		for k, exp := range n.seen {
+			if now.After(exp) {
+				delete(n.seen, k)
+			}
+		}
+	}
+	if len(n.seen) >= n.max {
+		// refuse instead of forgetting nonces which are still inside the window
+		return errNonceCacheFull
+	}
+	n.seen[nonce] = expires
+	return nil
+}
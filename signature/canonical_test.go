@@ -0,0 +1,33 @@
+package signature
+
+import (
+	"errors"
+	"github.com/valyala/fasthttp"
+	"strings"
+	"testing"
+)
+
+func newRequest(uri string) *fasthttp.Request {
+	req := &fasthttp.Request{}
+	req.Header.SetMethod("GET")
+	req.SetRequestURI(uri)
+	return req
+}
+
+func TestCanonicalRequestSortsQuery(t *testing.T) {
+	s, err := CanonicalRequest(newRequest("http://api.example.com/a?b=2&a=1"), nil, "1700000000", "n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(s, "\n"); lines[2] != "a=1&b=2" {
+		t.Fatalf("query line = %q", lines[2])
+	}
+}
+
+func TestCanonicalRequestRejectsMalformedQuery(t *testing.T) {
+	// a lenient parser would drop x=%zz and sign the rest, the client and the server would disagree on it
+	s, err := CanonicalRequest(newRequest("http://api.example.com/a?x=%zz&admin=1"), nil, "1700000000", "n")
+	if !errors.Is(err, ErrMalformedQuery) || s != "" {
+		t.Fatalf("CanonicalRequest = %q, %v, want ErrMalformedQuery", s, err)
+	}
+}
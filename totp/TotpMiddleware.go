@@ -0,0 +1,287 @@
+package totp
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/linxlib/conv"
+	"github.com/linxlib/fw"
+	"github.com/linxlib/fw_middlewares/auth"
+	"github.com/linxlib/fw_middlewares/basic_auth"
+	"github.com/linxlib/fw_middlewares/session"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ fw.IMiddlewareCtl = (*TotpMiddleware)(nil)
+
+const (
+	totpAttr = "TOTP"
+	totpName = "TOTP"
+
+	// session value "user|unix time" of the last successful verification
+	sessionVerifiedKey = "totp_verified"
+	recoveryCodeCount  = 10
+)
+
+type TotpOption struct {
+	Store    string `yaml:"store" default:"./data/totp.json"` // file store path
+	Issuer   string `yaml:"issuer" default:"fw"`              // shown in authenticator apps
+	Header   string `yaml:"header" default:"X-TOTP-Code"`     // request header carrying the code or a recovery code
+	Remember string `yaml:"remember" default:"12h"`           // how long a verification is remembered in the session, 0 to always ask
+	AdminKey string `yaml:"admin_key" default:""`             // sent in the X-Admin-Key header, enrollment routes are disabled when empty
+	Path     string `yaml:"path" default:"/totp"`             // base path of enrollment routes
+
+	MaxAttempts int    `yaml:"max_attempts" default:"5"` // consecutive wrong codes before the user is locked out, 0 disables it
+	Lockout     string `yaml:"lockout" default:"5m"`     // doubled on every further lockout, up to 24h
+}
+
+// TotpMiddleware requires a TOTP second factor after primary authentication,
+// it must be placed after a middleware which sets auth.Principal or basic_auth.AuthUserKey.
+// can be used on Controller
+//
+//	// @BasicAuth admin=secret
+//	// @TOTP remember=1h
+type TotpMiddleware struct {
+	*fw.MiddlewareCtl
+	options  *TotpOption
+	store    Store
+	attempts *auth.AttemptLimiter
+	routed   bool
+	mu       sync.Mutex
+}
+
+func (t *TotpMiddleware) DoInitOnce() {
+	t.LoadConfig("totp", t.options)
+	if t.store == nil {
+		store, err := NewFileStore(t.options.Store)
+		if err != nil {
+			panic("totp store: " + err.Error())
+		}
+		t.store = store
+	}
+	lockout, err := time.ParseDuration(t.options.Lockout)
+	if err != nil {
+		panic("totp: invalid lockout: " + err.Error())
+	}
+	// a user who is not enrolled has nothing to guess
+	t.attempts = auth.NewAttemptLimiter(t.options.MaxAttempts, lockout, ErrNotEnrolled)
+}
+
+func tooManyAttempts(context *fw.Context, wait time.Duration) {
+	context.GetFastContext().Response.Header.Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second)/time.Second)))
+	context.JSON(http.StatusTooManyRequests, fw.H{"error": "too many invalid totp codes, try again later"})
+}
+
+// verify checks a TOTP code or consumes a recovery code
+func (t *TotpMiddleware) verify(user string, code string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, err := t.store.Get(user)
+	if err != nil {
+		return err
+	}
+	if !e.Confirmed {
+		return ErrNotEnrolled
+	}
+	code = strings.TrimSpace(code)
+	if counter, ok := validate(e.Secret, code, time.Now(), e.LastCounter); ok {
+		e.LastCounter = counter
+		return t.store.Save(e)
+	}
+	hashed := hashRecoveryCode(code)
+	for i, rc := range e.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(rc), []byte(hashed)) == 1 {
+			// recovery codes are single use
+			e.RecoveryCodes = slices.Delete(e.RecoveryCodes, i, i+1)
+			return t.store.Save(e)
+		}
+	}
+	return errors.New("invalid totp code")
+}
+
+func remembered(sess *session.Session, user string, remember time.Duration) bool {
+	if sess == nil || remember <= 0 {
+		return false
+	}
+	who, at, found := strings.Cut(sess.GetString(sessionVerifiedKey), "|")
+	if !found || who != user {
+		return false
+	}
+	sec, err := strconv.ParseInt(at, 10, 64)
+	return err == nil && time.Since(time.Unix(sec, 0)) < remember
+}
+
+func (t *TotpMiddleware) Execute(ctx *fw.MiddlewareContext) fw.HandlerFunc {
+	remember := t.options.Remember
+	if v := ctx.GetParam("remember"); v != "" {
+		remember = v
+	}
+	rememberFor, err := time.ParseDuration(remember)
+	if err != nil {
+		panic("totp: invalid remember: " + err.Error())
+	}
+	return func(context *fw.Context) {
+		principal := auth.FromContext(context)
+		var user string
+		if principal != nil {
+			user = principal.ID
+		} else {
+			v, _ := context.Get(basic_auth.AuthUserKey)
+			user, _ = v.(string)
+		}
+		if user == "" {
+			context.JSON(http.StatusUnauthorized, fw.H{"error": "primary authentication required"})
+			return
+		}
+		sess := session.FromContext(context)
+		if remembered(sess, user, rememberFor) {
+			if principal != nil {
+				principal.SetAttribute("mfa", "totp")
+			}
+			ctx.Next(context)
+			return
+		}
+		code := conv.String(context.GetFastContext().Request.Header.Peek(t.options.Header))
+		if code == "" {
+			context.JSON(http.StatusUnauthorized, fw.H{"error": "totp code required"})
+			return
+		}
+		wait, err := t.attempts.Try(user, time.Now(), func() error {
+			return t.verify(user, code)
+		})
+		if errors.Is(err, ErrNotEnrolled) {
+			context.JSON(http.StatusForbidden, fw.H{"error": err.Error()})
+			return
+		}
+		if wait > 0 {
+			tooManyAttempts(context, wait)
+			return
+		}
+		if err != nil {
+			context.JSON(http.StatusUnauthorized, fw.H{"error": err.Error()})
+			return
+		}
+		if sess != nil && rememberFor > 0 {
+			sess.Set(sessionVerifiedKey, user+"|"+strconv.FormatInt(time.Now().Unix(), 10))
+		}
+		if principal != nil {
+			principal.SetAttribute("mfa", "totp")
+		}
+		ctx.Next(context)
+	}
+}
+
+// Router registers hidden enrollment routes
+func (t *TotpMiddleware) Router(ctx *fw.MiddlewareContext) []*fw.RouteItem {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// the middleware may be attached to many controllers, only register once
+	if t.routed {
+		return nil
+	}
+	t.routed = true
+	return auth.AdminRoutes(t.options.AdminKey,
+		&fw.RouteItem{
+			Method:     "POST",
+			Path:       t.options.Path + "/enroll/{user}",
+			H:          t.enroll,
+			Middleware: t,
+		},
+		&fw.RouteItem{
+			Method:     "POST",
+			Path:       t.options.Path + "/confirm/{user}",
+			H:          t.confirm,
+			Middleware: t,
+		},
+		&fw.RouteItem{
+			Method:     "DELETE",
+			Path:       t.options.Path + "/{user}",
+			H:          t.reset,
+			Middleware: t,
+		},
+	)
+}
+
+// enroll generates the secret, otpauth uri and recovery codes, they are only returned once
+func (t *TotpMiddleware) enroll(context *fw.Context) {
+	user, _ := context.GetFastContext().UserValue("user").(string)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e, err := t.store.Get(user); err == nil && e.Confirmed {
+		context.JSON(http.StatusConflict, fw.H{"error": "totp already enrolled, reset it first"})
+		return
+	}
+	secret := newSecret()
+	plain, hashed := newRecoveryCodes(recoveryCodeCount)
+	e := &Enrollment{
+		User:          user,
+		Secret:        secret,
+		RecoveryCodes: hashed,
+		CreatedAt:     time.Now(),
+	}
+	if err := t.store.Save(e); err != nil {
+		context.JSON(http.StatusInternalServerError, fw.H{"error": err.Error()})
+		return
+	}
+	context.JSON(http.StatusOK, fw.H{
+		"secret":         secret,
+		"uri":            keyURI(t.options.Issuer, user, secret),
+		"recovery_codes": plain,
+	})
+}
+
+// confirm activates an enrollment once the user proves the authenticator works
+func (t *TotpMiddleware) confirm(context *fw.Context) {
+	user, _ := context.GetFastContext().UserValue("user").(string)
+	code := conv.String(context.GetFastContext().Request.Header.Peek(t.options.Header))
+	if code == "" {
+		code = conv.String(context.GetFastContext().FormValue("code"))
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, err := t.store.Get(user)
+	if err != nil {
+		context.JSON(http.StatusNotFound, fw.H{"error": err.Error()})
+		return
+	}
+	counter, ok := validate(e.Secret, strings.TrimSpace(code), time.Now(), e.LastCounter)
+	if !ok {
+		context.JSON(http.StatusUnauthorized, fw.H{"error": "invalid totp code"})
+		return
+	}
+	e.Confirmed = true
+	e.LastCounter = counter
+	if err = t.store.Save(e); err != nil {
+		context.JSON(http.StatusInternalServerError, fw.H{"error": err.Error()})
+		return
+	}
+	context.String(http.StatusOK, "ok")
+}
+
+func (t *TotpMiddleware) reset(context *fw.Context) {
+	user, _ := context.GetFastContext().UserValue("user").(string)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.store.Delete(user); err != nil {
+		context.JSON(http.StatusInternalServerError, fw.H{"error": err.Error()})
+		return
+	}
+	context.String(http.StatusOK, "ok")
+}
+
+// NewTotpMiddleware creates the middleware, enrollments are kept in a json file store
+// configured by totp.store unless a custom store is given.
+func NewTotpMiddleware(store ...Store) fw.IMiddlewareCtl {
+	t := &TotpMiddleware{
+		MiddlewareCtl: fw.NewMiddlewareCtl(totpName, totpAttr),
+		options:       new(TotpOption),
+	}
+	if len(store) > 0 {
+		t.store = store[0]
+	}
+	return t
+}
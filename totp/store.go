@@ -0,0 +1,89 @@
+package totp
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/linxlib/fw_middlewares/auth"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrNotEnrolled = errors.New("totp not enrolled")
+
+// Enrollment is the second factor of a user
+type Enrollment struct {
+	User          string    `json:"user"`
+	Secret        string    `json:"secret"`
+	Confirmed     bool      `json:"confirmed"`
+	RecoveryCodes []string  `json:"recovery_codes"` // sha256 of unused recovery codes
+	LastCounter   int64     `json:"last_counter"`   // last accepted time step, prevents replay
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Store persists enrollments
+type Store interface {
+	Get(user string) (*Enrollment, error)
+	Save(e *Enrollment) error
+	Delete(user string) error
+}
+
+// FileStore keeps all enrollments in a single json file
+type FileStore struct {
+	path        string
+	mu          sync.RWMutex
+	enrollments map[string]*Enrollment
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	fs := &FileStore{
+		path:        path,
+		enrollments: make(map[string]*Enrollment),
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fs, nil
+		}
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &fs.enrollments); err != nil {
+			return nil, err
+		}
+	}
+	return fs, nil
+}
+
+func (f *FileStore) Get(user string) (*Enrollment, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	e, ok := f.enrollments[user]
+	if !ok {
+		return nil, ErrNotEnrolled
+	}
+	c := *e
+	c.RecoveryCodes = append([]string(nil), e.RecoveryCodes...)
+	return &c, nil
+}
+
+func (f *FileStore) Save(e *Enrollment) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := *e
+	c.RecoveryCodes = append([]string(nil), e.RecoveryCodes...)
+	f.enrollments[e.User] = &c
+	return f.flush()
+}
+
+func (f *FileStore) Delete(user string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.enrollments, user)
+	return f.flush()
+}
+
+// flush stores all enrollments, f.mu must be held
+func (f *FileStore) flush() error {
+	return auth.WriteJSONFile(f.path, f.enrollments)
+}
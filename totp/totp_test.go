@@ -0,0 +1,42 @@
+package totp
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func currentCode(t *testing.T, secret string, now time.Time) string {
+	key, err := b32.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hotp(key, uint64(now.Unix()/period))
+}
+
+func TestVerifyRejectsReplay(t *testing.T) {
+	store, err := NewFileStore(filepath.Join(t.TempDir(), "totp.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := newSecret()
+	if err = store.Save(&Enrollment{User: "alice", Secret: secret, Confirmed: true}); err != nil {
+		t.Fatal(err)
+	}
+	m := &TotpMiddleware{store: store}
+	code := currentCode(t, secret, time.Now())
+	if err = m.verify("alice", code); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err = m.verify("alice", code); err == nil {
+		t.Fatal("the code was accepted twice")
+	}
+	// the accepted step is persisted, a restart does not reopen the window
+	reopened, err := NewFileStore(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = (&TotpMiddleware{store: reopened}).verify("alice", code); err == nil {
+		t.Fatal("the code was accepted after a restart")
+	}
+}